@@ -0,0 +1,275 @@
+package fswatch
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/fswatch/fswatch/internal"
+	"github.com/fswatch/fswatch/internal/poller"
+)
+
+// Backend is an implementation of filesystem watching, such as a FUSE layer
+// or a remote agent. Backends are registered by name with Register, and
+// selected with Options.Backend.
+//
+// A Backend is given absolute paths, with symlinks resolved as configured.
+// The paths of the events it reports are mapped back to the paths the user
+// asked for, and the events are filtered as configured, before they reach
+// any Observer.
+type Backend interface {
+	// Files watches a list of files, calling obs with batches of events.
+	Files(paths []string, obs BatchObserver, opts BackendOptions) (*BackendWatch, error)
+
+	// Recursively watches all files/folders under the given path, calling obs
+	// with batches of events. If a recursive watch is not supported,
+	// it returns ErrRecursiveUnsupported.
+	Recursively(path string, obs BatchObserver, opts BackendOptions) (*BackendWatch, error)
+}
+
+// PathResolver can be implemented by a Backend whose paths are not on the
+// local filesystem, such as a remote agent, to resolve the paths given by
+// the user into the paths it reports events for.
+type PathResolver interface {
+	ResolvePath(path string) (string, error)
+}
+
+// FileReader can be implemented by a Backend which is a PathResolver, to read
+// the ignore files of watches with GitIgnore. The paths of a PathResolver are
+// never read from the local filesystem, so without it they have no rules.
+type FileReader interface {
+	ReadFile(name string) ([]byte, error)
+}
+
+// BatchObserver observes a batch of events from a Backend. If it returns
+// an error, the Backend must close the watch with that error, and not call
+// the observer again.
+type BatchObserver func(evts []Event) error
+
+// BackendOptions are the settings of a single watch started by a Backend.
+// Events are filtered before they reach any Observer anyway, so a Backend
+// may ignore them, but they allow it to skip work.
+type BackendOptions struct {
+	o *internal.WatchOptions
+}
+
+// SkipDir reports whether a directory below the root of a recursive watch
+// should not be watched, along with everything below it.
+func (x BackendOptions) SkipDir(dir string) bool {
+	return x.o.SkipDir(dir)
+}
+
+// Wants reports whether events of type t are wanted.
+func (x BackendOptions) Wants(t EventType) bool {
+	return x.o.Wants(internal.EventType(t))
+}
+
+// NoFollow reports whether watched symlinks should be watched themselves,
+// instead of their targets.
+func (x BackendOptions) NoFollow() bool {
+	return x.o != nil && x.o.NoFollow
+}
+
+// Stat returns the FileInfo of a watched path, which describes the symlink
+// itself when symlinks are not followed.
+func (x BackendOptions) Stat(name string) (os.FileInfo, error) {
+	return x.o.Stat(name)
+}
+
+// BackendWatch tracks the lifetime of a watch started by a Backend.
+type BackendWatch struct {
+	w *internal.Watch
+}
+
+// PathSet adds and removes the paths of a running watch.
+type PathSet interface {
+	Add(paths []string) error
+	Remove(paths []string) error
+}
+
+// NewBackendWatch returns a running BackendWatch, which calls stop when it is closed.
+func NewBackendWatch(stop func()) *BackendWatch {
+	return &BackendWatch{w: internal.NewWatch(stop)}
+}
+
+// Close ends the watch, recording err as the reason it ended. A Backend
+// calls it when the watch fails, or when its observer returned an error.
+// Only the first call has any effect.
+func (x *BackendWatch) Close(err error) {
+	x.w.Close(err)
+}
+
+// Done returns a channel that is closed once the watch has ended.
+func (x *BackendWatch) Done() <-chan struct{} {
+	return x.w.Done()
+}
+
+// SetPaths makes the watch support adding and removing paths while it runs,
+// through Watch.Add and Watch.Remove, which return ErrUnsupported otherwise.
+func (x *BackendWatch) SetPaths(p PathSet) {
+	x.w.Paths = p
+}
+
+var (
+	backendsMu sync.RWMutex
+	backends   = map[string]func(o Options) (watcher, error){
+		"poller": newPoller,
+	}
+)
+
+// Register makes a Backend available by name to NewWithOptions, which calls
+// newBackend with its Options. It panics if the name is empty or already
+// registered, so it is usually called from an init function.
+func Register(name string, newBackend func(o Options) (Backend, error)) {
+	if name == "" || newBackend == nil {
+		panic("fswatch: Register needs a name and a backend")
+	}
+	register(name, func(o Options) (watcher, error) {
+		b, err := newBackend(o)
+		if err != nil {
+			return nil, err
+		}
+		return &adapter{b: b}, nil
+	})
+}
+
+func register(name string, fn func(o Options) (watcher, error)) {
+	backendsMu.Lock()
+	defer backendsMu.Unlock()
+	if _, dup := backends[name]; dup {
+		panic("fswatch: Register called twice for backend " + name)
+	}
+	backends[name] = fn
+}
+
+// NewWithBackend is like NewWithOptions, but uses b instead
+// of the backend named by the options.
+func NewWithBackend(b Backend, o Options) (Interface, error) {
+	if err := o.validate(); err != nil {
+		return nil, err
+	}
+	return &wrap{w: &adapter{b: b}, opts: o.watchOptions()}, nil
+}
+
+// NewBackend returns the backend selected by the options as a Backend, the
+// built-in ones included, so that it can be wrapped, such as to record its
+// events, and used with NewWithBackend.
+func NewBackend(o Options) (Backend, error) {
+	if err := o.validate(); err != nil {
+		return nil, err
+	}
+	w, err := newBackend(o)
+	if err != nil {
+		return nil, err
+	}
+	if a, ok := w.(*adapter); ok {
+		return a.b, nil
+	}
+	return builtin{w}, nil
+}
+
+// Backends returns the sorted names of the available backends.
+func Backends() []string {
+	backendsMu.RLock()
+	defer backendsMu.RUnlock()
+	names := make([]string, 0, len(backends))
+	for name := range backends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// newBackend returns the backend selected by the options.
+func newBackend(o Options) (watcher, error) {
+	name := o.Backend
+	if name == "" {
+		name = defaultBackend
+	}
+	backendsMu.RLock()
+	fn, ok := backends[name]
+	backendsMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("fswatch: unknown backend %q", o.Backend)
+	}
+	return fn(o)
+}
+
+func newPoller(o Options) (watcher, error) {
+	return &poller.Interface{
+		Latency:   o.latency(),
+		Hash:      o.Hash,
+		HashLimit: o.hashLimit(),
+	}, nil
+}
+
+// adapter runs a Backend as an internal watcher.
+type adapter struct {
+	b Backend
+}
+
+func (x *adapter) Files(paths []string, obs internal.ObserveFunc, opts *internal.WatchOptions) (*internal.Watch, error) {
+	bw, err := x.b.Files(paths, batch(obs), BackendOptions{opts})
+	if err != nil {
+		return nil, err
+	}
+	return bw.w, nil
+}
+
+func (x *adapter) Recursively(path string, obs internal.ObserveFunc, opts *internal.WatchOptions) (*internal.Watch, error) {
+	bw, err := x.b.Recursively(path, batch(obs), BackendOptions{opts})
+	if err != nil {
+		return nil, err
+	}
+	return bw.w, nil
+}
+
+// builtin runs an internal watcher as a Backend.
+type builtin struct {
+	w watcher
+}
+
+func (x builtin) Files(paths []string, obs BatchObserver, opts BackendOptions) (*BackendWatch, error) {
+	w, err := x.w.Files(paths, unbatch(obs), opts.o)
+	if err == internal.ErrNotImplemented {
+		return nil, ErrUnsupported
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &BackendWatch{w: w}, nil
+}
+
+func (x builtin) Recursively(path string, obs BatchObserver, opts BackendOptions) (*BackendWatch, error) {
+	w, err := x.w.Recursively(path, unbatch(obs), opts.o)
+	if err == internal.ErrNotImplemented {
+		return nil, ErrRecursiveUnsupported
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &BackendWatch{w: w}, nil
+}
+
+// unbatch converts the events of an internal watcher for a Backend observer.
+func unbatch(obs BatchObserver) internal.ObserveFunc {
+	return func(ievts []internal.Event) error {
+		evts := make([]Event, len(ievts))
+		for i, e := range ievts {
+			evts[i] = Event{Path: e.Path, OldPath: e.OldPath, Type: EventType(e.Type), IsDir: e.IsDir}
+		}
+		return obs(evts)
+	}
+}
+
+// batch converts the events of a Backend for an internal observer.
+func batch(obs internal.ObserveFunc) BatchObserver {
+	return func(evts []Event) error {
+		ievts := make([]internal.Event, len(evts))
+		for i, e := range evts {
+			ievts[i] = internal.Event{Path: e.Path, OldPath: e.OldPath, Type: internal.EventType(e.Type), IsDir: e.IsDir}
+		}
+		return obs(ievts)
+	}
+}
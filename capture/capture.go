@@ -0,0 +1,263 @@
+// Package capture records streams of events to a file, and replays them,
+// so that a misbehaving watch can be reproduced from a capture.
+//
+// A capture is a JSON-lines file. The first line is a header with the
+// format version and the time recording started, and every other line
+// is an event with the time it was observed, and the number of the batch
+// it was delivered in:
+//
+//	{"format":"fswatch-capture","version":2,"start":"2022-07-15T15:14:00.5Z"}
+//	{"time":"2022-07-15T15:14:01.25Z","batch":1,"type":"CREATED","path":"/src/a.go"}
+//	{"time":"2022-07-15T15:14:01.25Z","batch":1,"type":"MODIFIED","path":"/src/a.go"}
+//	{"time":"2022-07-15T15:14:02Z","batch":2,"type":"RENAMED","path":"/src/b.go","old_path":"/src/a.go"}
+//
+// A Recorder writes a capture while watching, and a Replayer is a
+// fswatch.Backend which delivers the events of a capture again, in the
+// same batches:
+//
+//	c, err := capture.Read(f)
+//	...
+//	x, err := fswatch.NewWithBackend(capture.NewReplayer(c), fswatch.Options{})
+//	...
+//	w, err := x.Recursively("/src", obs)
+//
+// The events of a built-in backend are recorded by wrapping the Backend
+// returned by fswatch.NewBackend:
+//
+//	b, err := fswatch.NewBackend(o)
+//	...
+//	x, err := fswatch.NewWithBackend(rec.Backend(b), o)
+package capture
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fswatch/fswatch"
+)
+
+const (
+	formatName = "fswatch-capture"
+
+	// Version is the version of the capture format written by a Recorder.
+	// Read accepts captures of this version or older. Captures of version
+	// 1 have no batches, and each of their events is a batch of its own.
+	Version = 2
+)
+
+type header struct {
+	Format  string    `json:"format"`
+	Version int       `json:"version"`
+	Start   time.Time `json:"start"`
+}
+
+type line struct {
+	Time    time.Time `json:"time"`
+	Batch   int       `json:"batch,omitempty"`
+	Type    string    `json:"type"`
+	Path    string    `json:"path,omitempty"`
+	OldPath string    `json:"old_path,omitempty"`
+}
+
+// Record is an event of a capture, with the time it was observed.
+type Record struct {
+	Time time.Time
+
+	// Batch numbers the batch the event was delivered in. The events of
+	// a batch are consecutive, and have the same number.
+	Batch int
+
+	fswatch.Event
+}
+
+// Capture is a stream of events read from a file.
+type Capture struct {
+	Start   time.Time // when recording started
+	Records []Record
+}
+
+// Read reads a capture written by a Recorder.
+func Read(r io.Reader) (*Capture, error) {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, 1<<20)
+
+	var h header
+	if !sc.Scan() {
+		if err := sc.Err(); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("capture: empty capture")
+	}
+	if err := json.Unmarshal(sc.Bytes(), &h); err != nil || h.Format != formatName {
+		return nil, fmt.Errorf("capture: not a capture")
+	}
+	if h.Version < 1 || h.Version > Version {
+		return nil, fmt.Errorf("capture: unsupported version %d", h.Version)
+	}
+
+	c := &Capture{Start: h.Start}
+	for n := 2; sc.Scan(); n++ {
+		var l line
+		if err := json.Unmarshal(sc.Bytes(), &l); err != nil {
+			return nil, fmt.Errorf("capture: line %d: %w", n, err)
+		}
+		t, ok := fswatch.ParseEventType(l.Type)
+		if !ok {
+			return nil, fmt.Errorf("capture: line %d: unknown event type %q", n, l.Type)
+		}
+		if h.Version < 2 {
+			l.Batch = n - 1
+		}
+		rec := Record{Time: l.Time, Batch: l.Batch, Event: fswatch.Event{Type: t}}
+		if l.Path != "" {
+			rec.Path = filepath.Clean(l.Path)
+		}
+		if l.OldPath != "" {
+			rec.OldPath = filepath.Clean(l.OldPath)
+		}
+		c.Records = append(c.Records, rec)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Recorder writes the events it is given to a capture. It is safe for
+// concurrent use, so it can record any number of watches at once.
+type Recorder struct {
+	mu      sync.Mutex
+	enc     *json.Encoder
+	err     error
+	batches int // the number of the last batch
+}
+
+// NewRecorder returns a Recorder writing a capture to w,
+// after writing the header of the capture.
+func NewRecorder(w io.Writer) (*Recorder, error) {
+	enc := json.NewEncoder(w)
+	err := enc.Encode(header{Format: formatName, Version: Version, Start: time.Now()})
+	if err != nil {
+		return nil, err
+	}
+	return &Recorder{enc: enc}, nil
+}
+
+// Record writes ev to the capture as a batch of its own, observed now.
+// Once writing failed, nothing more is recorded, and the error is returned
+// by Err.
+func (r *Recorder) Record(ev fswatch.Event) {
+	r.RecordBatch([]fswatch.Event{ev})
+}
+
+// RecordBatch writes events delivered together to the capture as a batch,
+// observed now.
+func (r *Recorder) RecordBatch(evts []fswatch.Event) {
+	now := time.Now()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.batches++
+	for _, ev := range evts {
+		if r.err != nil {
+			return
+		}
+		r.err = r.enc.Encode(line{
+			Time:    now,
+			Batch:   r.batches,
+			Type:    ev.Type.String(),
+			Path:    ev.Path,
+			OldPath: ev.OldPath,
+		})
+	}
+}
+
+// Err returns the error which stopped recording, if any.
+func (r *Recorder) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err
+}
+
+// Observer returns an Observer which records the events it passes to obs,
+// so a capture has the paths the observer sees. The events are recorded in
+// the batches they were delivered in, which are passed on if obs is a
+// fswatch.Flusher. A failure to write the capture does not end the watch.
+func (r *Recorder) Observer(obs fswatch.Observer) fswatch.Observer {
+	return &recordingObserver{r: r, obs: obs}
+}
+
+// recordingObserver is an Observer recording the events of a watch.
+type recordingObserver struct {
+	r     *Recorder
+	obs   fswatch.Observer
+	batch []fswatch.Event // the events of the current batch
+}
+
+func (x *recordingObserver) Observe(ev fswatch.Event) error {
+	x.batch = append(x.batch, ev)
+	err := x.obs.Observe(ev)
+	if err != nil {
+		// the watch ends without flushing
+		x.record()
+	}
+	return err
+}
+
+func (x *recordingObserver) Flush() error {
+	x.record()
+	if f, ok := x.obs.(fswatch.Flusher); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+// record records the current batch.
+func (x *recordingObserver) record() {
+	if len(x.batch) > 0 {
+		x.r.RecordBatch(x.batch)
+		x.batch = x.batch[:0]
+	}
+}
+
+// Backend returns a Backend which records the events of b before they are
+// filtered, so a capture has everything b reported, with its paths.
+// Select it with fswatch.NewWithBackend, or add it with fswatch.Register.
+func (r *Recorder) Backend(b fswatch.Backend) fswatch.Backend {
+	rb := recordingBackend{b: b, r: r}
+	if pr, ok := b.(fswatch.PathResolver); ok {
+		return resolvingBackend{rb, pr}
+	}
+	return rb
+}
+
+// recordingBackend is a Backend recording the events of another Backend.
+type recordingBackend struct {
+	b fswatch.Backend
+	r *Recorder
+}
+
+// resolvingBackend is a recordingBackend of a Backend which resolves paths.
+type resolvingBackend struct {
+	recordingBackend
+	fswatch.PathResolver
+}
+
+func (x recordingBackend) Files(paths []string, obs fswatch.BatchObserver, opts fswatch.BackendOptions) (*fswatch.BackendWatch, error) {
+	return x.b.Files(paths, x.record(obs), opts)
+}
+
+func (x recordingBackend) Recursively(path string, obs fswatch.BatchObserver, opts fswatch.BackendOptions) (*fswatch.BackendWatch, error) {
+	return x.b.Recursively(path, x.record(obs), opts)
+}
+
+func (x recordingBackend) record(obs fswatch.BatchObserver) fswatch.BatchObserver {
+	return func(evts []fswatch.Event) error {
+		x.r.RecordBatch(evts)
+		return obs(evts)
+	}
+}
@@ -0,0 +1,115 @@
+package capture
+
+import (
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fswatch/fswatch"
+)
+
+// Replayer is a fswatch.Backend which delivers the events of a capture to
+// every watch started with it, in the batches and with the delays between
+// them as recorded. Each watch replays the capture from its start, receiving
+// the events for its paths, and ends once every event was replayed.
+//
+// Paths are never looked up on disk, so the paths watched must be written
+// as in the capture: absolute for a capture of a Backend, and as given to
+// the watch for a capture of an Observer.
+type Replayer struct {
+	// Speed is how many times faster than recorded events are replayed.
+	// Zero replays them at the original speed, and a negative value
+	// replays them without any delay.
+	Speed float64
+
+	c *Capture
+}
+
+// NewReplayer returns a Replayer of c, at the original speed.
+func NewReplayer(c *Capture) *Replayer {
+	return &Replayer{c: c}
+}
+
+// ResolvePath implements fswatch.PathResolver, so the paths of a watch
+// are matched against the capture as they are written.
+func (x *Replayer) ResolvePath(path string) (string, error) {
+	return filepath.Clean(path), nil
+}
+
+func (x *Replayer) Files(paths []string, obs fswatch.BatchObserver, opts fswatch.BackendOptions) (*fswatch.BackendWatch, error) {
+	return x.start(paths, false, obs), nil
+}
+
+func (x *Replayer) Recursively(path string, obs fswatch.BatchObserver, opts fswatch.BackendOptions) (*fswatch.BackendWatch, error) {
+	return x.start([]string{path}, true, obs), nil
+}
+
+func (x *Replayer) start(paths []string, recursive bool, obs fswatch.BatchObserver) *fswatch.BackendWatch {
+	bw := fswatch.NewBackendWatch(nil)
+	go x.run(bw, paths, recursive, obs)
+	return bw
+}
+
+func (x *Replayer) run(bw *fswatch.BackendWatch, paths []string, recursive bool, obs fswatch.BatchObserver) {
+	covers := func(p string) bool {
+		for _, wp := range paths {
+			if p == wp || (recursive && strings.HasPrefix(p, strings.TrimSuffix(wp, "/")+"/")) {
+				return true
+			}
+		}
+		return false
+	}
+
+	prev := x.c.Start
+	recs := x.c.Records
+	for len(recs) > 0 {
+		n := 1
+		for n < len(recs) && recs[n].Batch == recs[0].Batch {
+			n++
+		}
+		batch := recs[:n]
+		recs = recs[n:]
+
+		if d := x.delay(batch[0].Time.Sub(prev)); d > 0 {
+			t := time.NewTimer(d)
+			select {
+			case <-t.C:
+			case <-bw.Done():
+				t.Stop()
+				return
+			}
+		}
+		prev = batch[0].Time
+
+		select {
+		case <-bw.Done():
+			return
+		default:
+		}
+		var evts []fswatch.Event
+		for _, rec := range batch {
+			if rec.Type == fswatch.OVERFLOW || covers(rec.Path) || (rec.OldPath != "" && covers(rec.OldPath)) {
+				evts = append(evts, rec.Event)
+			}
+		}
+		if len(evts) == 0 {
+			continue
+		}
+		if err := obs(evts); err != nil {
+			bw.Close(err)
+			return
+		}
+	}
+	bw.Close(nil)
+}
+
+// delay returns how long to wait for a gap of d between recorded events.
+func (x *Replayer) delay(d time.Duration) time.Duration {
+	switch {
+	case x.Speed < 0:
+		return 0
+	case x.Speed == 0:
+		return d
+	}
+	return time.Duration(float64(d) / x.Speed)
+}
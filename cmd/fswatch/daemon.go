@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+
+	"github.com/fswatch/fswatch"
+	"github.com/fswatch/fswatch/daemon"
+)
+
+// daemonMain runs the daemon command, which serves watches on a socket.
+func daemonMain(args []string) {
+	fs := flag.NewFlagSet("fswatch daemon", flag.ExitOnError)
+	fs.Usage = usage(fs, "")
+	c := watchFlags(fs)
+	socket := fs.String("socket", filepath.Join(os.TempDir(), fmt.Sprintf("fswatch-%d.sock", os.Getuid())), "listen on the Unix domain socket at `path`")
+	fs.Parse(args)
+	if fs.NArg() != 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	o, err := c.options()
+	if err != nil {
+		log.Fatal(err)
+	}
+	x, err := fswatch.NewWithOptions(o)
+	if err != nil {
+		log.Fatal(err)
+	}
+	l, created, err := listen(*socket)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	s := daemon.NewServer(x)
+	if len(c.eventTypes) > 0 {
+		s.WatchOptions = append(s.WatchOptions, fswatch.OnlyEvents(c.eventTypes...))
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		s.Close()
+	}()
+
+	err = s.Serve(l)
+	unlink(*socket, created)
+	if !errors.Is(err, daemon.ErrServerClosed) {
+		log.Fatal(err)
+	}
+}
+
+// listen listens on the socket at path, which only the user may connect to,
+// and returns the socket file it created. A socket left behind by a daemon
+// which is no longer running is replaced, but nothing else is.
+func listen(path string) (*net.UnixListener, os.FileInfo, error) {
+	if fi, err := os.Lstat(path); err == nil {
+		if fi.Mode()&os.ModeSocket == 0 {
+			return nil, nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		if nc, err := net.Dial("unix", path); err == nil {
+			nc.Close()
+			return nil, nil, fmt.Errorf("a daemon is running on %s already", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// the socket is created without access for others, instead of
+	// restricting it after others could connect
+	mask := syscall.Umask(0177)
+	l, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	syscall.Umask(mask)
+	if err != nil {
+		return nil, nil, err
+	}
+	// the socket is removed by unlink, if it is still the one created here
+	l.SetUnlinkOnClose(false)
+	fi, err := os.Lstat(path)
+	if err != nil {
+		l.Close()
+		return nil, nil, err
+	}
+	return l, fi, nil
+}
+
+// unlink removes the socket at path if it is the one created by listen,
+// and not one a later daemon replaced it with.
+func unlink(path string, created os.FileInfo) {
+	if fi, err := os.Lstat(path); err == nil && os.SameFile(fi, created) {
+		os.Remove(path)
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/fswatch/fswatch"
+)
+
+// What to do with changes while the command is running.
+const (
+	busyQueue   = "queue"   // run the command again once it exits
+	busySkip    = "skip"    // ignore them
+	busyRestart = "restart" // stop the command and run it again
+)
+
+// execMain runs the exec command, which runs a command on changes.
+func execMain(args []string) {
+	fs := flag.NewFlagSet("fswatch exec", flag.ExitOnError)
+	fs.Usage = usage(fs, "[--] command [arg...]")
+	c := watchFlags(fs)
+	var paths stringList
+	fs.Var(&paths, "watch", "watch `path` (repeatable, default \".\")")
+	debounce := fs.Duration("debounce", 100*time.Millisecond, "run the command once no events were observed for `duration`")
+	busy := fs.String("on-busy", busyQueue, "on changes while the command runs: `policy` queue runs it again once it exits, skip ignores them, restart stops and reruns it")
+	initial := fs.Bool("initial", false, "run the command once at start, before any change")
+	grace := fs.Duration("grace", 5*time.Second, "kill the command if it did not stop `duration` after SIGTERM")
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	switch *busy {
+	case busyQueue, busySkip, busyRestart:
+	default:
+		log.Fatalf("unknown -on-busy policy %q", *busy)
+	}
+	if len(paths) == 0 {
+		paths = stringList{"."}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	x := &executor{
+		argv:     fs.Args(),
+		debounce: *debounce,
+		grace:    *grace,
+		busy:     *busy,
+		changes:  make(chan string, 64),
+	}
+	errc := make(chan error, 1)
+	go func() {
+		errc <- c.watch(ctx, paths, fswatch.ObserveEventFunc(func(ev fswatch.Event) error {
+			select {
+			case x.changes <- ev.Path:
+			case <-ctx.Done():
+			}
+			return nil
+		}))
+	}()
+
+	x.loop(ctx, *initial, errc)
+	if err := <-errc; err != nil {
+		log.Fatal(err)
+	}
+}
+
+// executor runs a command for batches of changed paths.
+type executor struct {
+	argv     []string
+	debounce time.Duration
+	grace    time.Duration
+	busy     string
+	changes  chan string // changed paths, as observed
+}
+
+// loop runs the command on changes until ctx is done or the watch failed,
+// stopping the command if it is still running.
+func (x *executor) loop(ctx context.Context, initial bool, errc chan error) {
+	var (
+		pending []string // changed paths for the next run, in order
+		seen    = make(map[string]bool)
+		settle  <-chan time.Time
+		queued  = initial // run once the command exits, or now
+		cmd     *exec.Cmd
+		exited  chan error
+		stopped bool             // the command was stopped for a restart
+		killing <-chan time.Time // when to kill the stopped command
+	)
+
+	for {
+		if queued && cmd == nil {
+			queued = false
+			cmd, exited = x.start(pending)
+			pending, seen = nil, make(map[string]bool)
+		}
+
+		select {
+		case p := <-x.changes:
+			if !seen[p] {
+				seen[p] = true
+				pending = append(pending, p)
+			}
+			settle = time.After(x.debounce)
+
+		case <-settle:
+			settle = nil
+			switch {
+			case cmd == nil || x.busy == busyQueue:
+				queued = true
+			case x.busy == busyRestart:
+				queued = true
+				if !stopped {
+					stopped = true
+					signalGroup(cmd, syscall.SIGTERM)
+					killing = time.After(x.grace)
+				}
+			case x.busy == busySkip:
+				pending, seen = nil, make(map[string]bool)
+			}
+
+		case <-killing:
+			killing = nil
+			kill(cmd, x.grace)
+
+		case err := <-exited:
+			cmd, exited, killing = nil, nil, nil
+			if err != nil && !stopped {
+				log.Printf("%s: %v", x.argv[0], err)
+			}
+			stopped = false
+
+		case err := <-errc:
+			errc <- err
+			if cmd != nil {
+				stopCommand(cmd, exited, syscall.SIGTERM, x.grace)
+			}
+			return
+
+		case <-ctx.Done():
+			if cmd != nil {
+				stopCommand(cmd, exited, syscall.SIGTERM, x.grace)
+			}
+			return
+		}
+	}
+}
+
+// start starts the command for the changed paths.
+func (x *executor) start(paths []string) (*exec.Cmd, chan error) {
+	return startCommand(expand(x.argv, paths), "FSWATCH_CHANGED="+strings.Join(paths, "\n"))
+}
+
+// startCommand starts a command in a process group of its own, with the
+// output of fswatch and additional environment variables, returning a channel
+// which receives the result once it exits. If it fails to start, the channel
+// receives the error right away.
+func startCommand(argv []string, env ...string) (*exec.Cmd, chan error) {
+	cmd := exec.Command(argv[0], argv[1:]...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(), env...)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	exited := make(chan error, 1)
+	if err := cmd.Start(); err != nil {
+		exited <- err
+		return cmd, exited
+	}
+	go func() {
+		exited <- cmd.Wait()
+	}()
+	return cmd, exited
+}
+
+// signalGroup sends sig to the process group of a started command,
+// or just to the command if that fails.
+func signalGroup(cmd *exec.Cmd, sig syscall.Signal) {
+	if cmd.Process == nil {
+		return
+	}
+	err := syscall.Kill(-cmd.Process.Pid, sig)
+	if err != nil && !errors.Is(err, syscall.ESRCH) {
+		cmd.Process.Signal(sig)
+	}
+}
+
+// expand replaces the placeholders in the arguments of a command with the
+// changed paths: an argument of just "{}" is replaced by the paths, one
+// argument each, and "{}" within an argument by the paths separated by spaces.
+func expand(argv []string, paths []string) []string {
+	res := make([]string, 0, len(argv))
+	for i, arg := range argv {
+		switch {
+		case i > 0 && arg == "{}":
+			res = append(res, paths...)
+		case strings.Contains(arg, "{}"):
+			res = append(res, strings.ReplaceAll(arg, "{}", strings.Join(paths, " ")))
+		default:
+			res = append(res, arg)
+		}
+	}
+	return res
+}
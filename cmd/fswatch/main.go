@@ -0,0 +1,109 @@
+// Command fswatch watches files and directories, printing their events.
+//
+// Usage:
+//
+//	fswatch [flags] path...
+//
+// Directories are watched recursively unless -recursive=false is given.
+// Each event is printed as a line such as "CREATED src/a.go", as a line of
+// JSON with -output json, or as just its path followed by a NUL byte with
+// -output nul, for xargs -0. The -format flag prints events with a
+// text/template instead, using the fields .Time, .Type, .Path and .OldPath:
+//
+//	fswatch -format '{{.Time.Unix}} {{.Type}} {{.Path}}' .
+//
+// fswatch runs until it is interrupted, or a watch fails.
+//
+// The exec command runs a command whenever the watched paths change:
+//
+//	fswatch exec [flags] [--] command [arg...]
+//
+// It watches the paths given with -watch, the current directory by default,
+// and runs the command once no events were observed for the -debounce
+// duration. An argument of just "{}" is replaced by the changed paths, one
+// argument each, and "{}" within an argument by the changed paths separated
+// by spaces; the FSWATCH_CHANGED environment variable holds them one per line.
+// The -on-busy policy decides what happens with changes while the command is
+// still running: queue runs it again once it exits, skip ignores them, and
+// restart stops it with SIGTERM and runs it again.
+//
+// The run command supervises a long-running process, such as a server in
+// development, restarting it whenever the watched paths change:
+//
+//	fswatch run [flags] [--] command [arg...]
+//
+// It watches the paths given with -watch like exec does. On changes, the
+// command is sent the -signal, SIGTERM by default, and killed if it did not
+// stop within the -grace period. Then the -build shell command runs, if any,
+// and the command is started again if the build succeeded. The output of both
+// is forwarded. When fswatch run is interrupted, it stops the command and
+// exits with its exit status; with -exit, it also exits once the command
+// exits by itself.
+//
+// The daemon command serves watches to other processes on a Unix domain
+// socket, so that they share a single watch of each root:
+//
+//	fswatch daemon [flags]
+//
+// It speaks the line-delimited JSON protocol of package daemon on the socket
+// given with -socket, fswatch-<uid>.sock in the temporary directory by
+// default. The watch flags apply to every root it watches.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+func usage(fs *flag.FlagSet, args string) func() {
+	return func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s [flags] %s\n\nFlags:\n", fs.Name(), args)
+		fs.PrintDefaults()
+	}
+}
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("fswatch: ")
+
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "exec":
+			execMain(os.Args[2:])
+			return
+		case "run":
+			runMain(os.Args[2:])
+			return
+		case "daemon":
+			daemonMain(os.Args[2:])
+			return
+		}
+	}
+
+	fs := flag.NewFlagSet("fswatch", flag.ExitOnError)
+	fs.Usage = usage(fs, "path...")
+	c := watchFlags(fs)
+	output := fs.String("output", "text", "print events as `text`, json or nul")
+	format := fs.String("format", "", "print events with a text/template `template` using the fields .Time, .Type, .Path and .OldPath")
+	fs.Parse(os.Args[1:])
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	p, err := newPrinter(os.Stdout, *output, *format)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := c.watch(ctx, fs.Args(), p); err != nil {
+		log.Fatal(err)
+	}
+}
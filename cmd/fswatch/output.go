@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/fswatch/fswatch"
+)
+
+// event is an event as it is printed, with the time it was observed.
+type event struct {
+	Time    time.Time         `json:"time"`
+	Type    fswatch.EventType `json:"-"`
+	Name    string            `json:"type"`
+	Path    string            `json:"path,omitempty"`
+	OldPath string            `json:"old_path,omitempty"`
+}
+
+// printer prints events to a writer, one at a time, flushing after each
+// event so that the output can drive a pipeline.
+type printer struct {
+	mu    sync.Mutex
+	w     *bufio.Writer
+	print func(w *bufio.Writer, ev event) error
+}
+
+// newPrinter returns a printer of events in the named form. If tmpl is not
+// empty, events are printed as described by the template instead, each
+// followed by a newline, or by a NUL byte in the "nul" form.
+func newPrinter(w io.Writer, form, tmpl string) (*printer, error) {
+	x := &printer{w: bufio.NewWriter(w)}
+	if tmpl != "" {
+		t, err := template.New("format").Parse(tmpl)
+		if err != nil {
+			return nil, err
+		}
+		end := byte('\n')
+		switch form {
+		case "text":
+		case "nul":
+			end = 0
+		default:
+			return nil, fmt.Errorf("a format can't be used with %s output", form)
+		}
+		x.print = func(w *bufio.Writer, ev event) error {
+			if err := t.Execute(w, ev); err != nil {
+				return err
+			}
+			return w.WriteByte(end)
+		}
+		return x, nil
+	}
+
+	switch form {
+	case "text":
+		x.print = printText
+	case "json":
+		x.print = printJSON
+	case "nul":
+		x.print = printNUL
+	default:
+		return nil, fmt.Errorf("unknown output form %q", form)
+	}
+	return x, nil
+}
+
+// Observe implements fswatch.Observer.
+func (x *printer) Observe(ev fswatch.Event) error {
+	e := event{
+		Time:    time.Now(),
+		Type:    ev.Type,
+		Name:    ev.Type.String(),
+		Path:    ev.Path,
+		OldPath: ev.OldPath,
+	}
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if err := x.print(x.w, e); err != nil {
+		return err
+	}
+	return x.w.Flush()
+}
+
+// printText prints a line with the type and path of the event,
+// such as "CREATED a.go" or "RENAMED a.go -> b.go".
+func printText(w *bufio.Writer, ev event) error {
+	var err error
+	switch {
+	case ev.Type == fswatch.OVERFLOW:
+		_, err = fmt.Fprintln(w, ev.Name)
+	case ev.OldPath != "":
+		_, err = fmt.Fprintf(w, "%s %s -> %s\n", ev.Name, ev.OldPath, ev.Path)
+	default:
+		_, err = fmt.Fprintf(w, "%s %s\n", ev.Name, ev.Path)
+	}
+	return err
+}
+
+// printJSON prints the event as a line of JSON.
+func printJSON(w *bufio.Writer, ev event) error {
+	return json.NewEncoder(w).Encode(ev)
+}
+
+// printNUL prints just the path of the event followed by a NUL byte,
+// for xargs -0. Events without a path are not printed.
+func printNUL(w *bufio.Writer, ev event) error {
+	if ev.Path == "" {
+		return nil
+	}
+	_, err := w.WriteString(ev.Path + "\x00")
+	return err
+}
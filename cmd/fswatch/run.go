@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/fswatch/fswatch"
+)
+
+var signals = map[string]syscall.Signal{
+	"HUP":  syscall.SIGHUP,
+	"INT":  syscall.SIGINT,
+	"QUIT": syscall.SIGQUIT,
+	"KILL": syscall.SIGKILL,
+	"TERM": syscall.SIGTERM,
+	"USR1": syscall.SIGUSR1,
+	"USR2": syscall.SIGUSR2,
+}
+
+// parseSignal parses a signal name such as "TERM" or "SIGTERM".
+func parseSignal(s string) (syscall.Signal, error) {
+	sig, ok := signals[strings.TrimPrefix(strings.ToUpper(s), "SIG")]
+	if !ok {
+		return 0, fmt.Errorf("unknown signal %q", s)
+	}
+	return sig, nil
+}
+
+// runMain runs the run command, which restarts a process on changes.
+func runMain(args []string) {
+	fs := flag.NewFlagSet("fswatch run", flag.ExitOnError)
+	fs.Usage = usage(fs, "[--] command [arg...]")
+	c := watchFlags(fs)
+	var paths stringList
+	fs.Var(&paths, "watch", "watch `path` (repeatable, default \".\")")
+	debounce := fs.Duration("debounce", 100*time.Millisecond, "restart once no events were observed for `duration`")
+	build := fs.String("build", "", "run the shell `command` before each start, and only start if it succeeds")
+	signame := fs.String("signal", "TERM", "stop the command with `signal`")
+	grace := fs.Duration("grace", 5*time.Second, "kill the command if it did not stop `duration` after the signal")
+	exit := fs.Bool("exit", false, "exit with the status of the command when it exits by itself, instead of waiting for changes")
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	sig, err := parseSignal(*signame)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(paths) == 0 {
+		paths = stringList{"."}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	x := &supervisor{
+		argv:     fs.Args(),
+		build:    *build,
+		signal:   sig,
+		grace:    *grace,
+		debounce: *debounce,
+		exit:     *exit,
+		changes:  make(chan struct{}, 1),
+	}
+	errc := make(chan error, 1)
+	go func() {
+		errc <- c.watch(ctx, paths, fswatch.ObserveEventFunc(func(ev fswatch.Event) error {
+			select {
+			case x.changes <- struct{}{}:
+			default:
+				// a change is already waiting
+			}
+			return nil
+		}))
+	}()
+
+	code := x.loop(ctx, errc)
+	cancel()
+	if err := <-errc; err != nil {
+		log.Print(err)
+		if code == 0 {
+			code = 1
+		}
+	}
+	os.Exit(code)
+}
+
+// supervisor runs a command, and restarts it on changes.
+type supervisor struct {
+	argv     []string
+	build    string
+	signal   syscall.Signal
+	grace    time.Duration
+	debounce time.Duration
+	exit     bool
+	changes  chan struct{}
+}
+
+// loop builds and starts the command, and restarts it on changes until ctx
+// is done, the watch failed or, with exit, the command exited by itself.
+// It returns the exit status of the command.
+func (x *supervisor) loop(ctx context.Context, errc chan error) int {
+	var (
+		cmd     *exec.Cmd
+		exited  chan error
+		code    int
+		settle  <-chan time.Time
+		restart = true
+	)
+
+	for {
+		if restart {
+			restart = false
+			if cmd != nil {
+				code = x.stop(cmd, exited)
+				cmd, exited = nil, nil
+			}
+			if x.runBuild(ctx) {
+				cmd, exited = startCommand(x.argv)
+			}
+		}
+
+		select {
+		case <-x.changes:
+			settle = time.After(x.debounce)
+
+		case <-settle:
+			settle = nil
+			restart = true
+
+		case err := <-exited:
+			cmd, exited = nil, nil
+			code = exitCode(err)
+			if x.exit {
+				return code
+			}
+			log.Printf("%s exited: %v, waiting for changes", x.argv[0], describe(err))
+
+		case err := <-errc:
+			errc <- err
+			if cmd != nil {
+				code = x.stop(cmd, exited)
+			}
+			return code
+
+		case <-ctx.Done():
+			if cmd != nil {
+				code = x.stop(cmd, exited)
+			}
+			return code
+		}
+	}
+}
+
+// runBuild runs the build command, if any, reporting whether it succeeded.
+// The build is stopped if ctx is done.
+func (x *supervisor) runBuild(ctx context.Context) bool {
+	if x.build == "" {
+		return true
+	}
+	cmd, exited := startCommand([]string{"sh", "-c", x.build})
+	var err error
+	select {
+	case err = <-exited:
+	case <-ctx.Done():
+		x.stop(cmd, exited)
+		return false
+	}
+	if err != nil {
+		log.Printf("build failed: %v, waiting for changes", err)
+		return false
+	}
+	return true
+}
+
+// stop stops a running command with the signal, and kills it if it is still
+// running after the grace period, returning its exit status.
+func (x *supervisor) stop(cmd *exec.Cmd, exited chan error) int {
+	return stopCommand(cmd, exited, x.signal, x.grace)
+}
+
+// stopCommand stops a running command with sig, and kills it if it is still
+// running after grace, returning its exit status.
+func stopCommand(cmd *exec.Cmd, exited chan error, sig syscall.Signal, grace time.Duration) int {
+	signalGroup(cmd, sig)
+	t := time.NewTimer(grace)
+	defer t.Stop()
+
+	select {
+	case err := <-exited:
+		return exitCode(err)
+	case <-t.C:
+		kill(cmd, grace)
+		return exitCode(<-exited)
+	}
+}
+
+// kill kills a command which did not stop within grace after a signal.
+func kill(cmd *exec.Cmd, grace time.Duration) {
+	log.Printf("%s did not stop within %v, killing it", cmd.Args[0], grace)
+	signalGroup(cmd, syscall.SIGKILL)
+}
+
+// exitCode returns the exit status of a command from the error of
+// exec.Cmd.Wait, which is 128 plus the signal if it was killed by one,
+// as reported by shells.
+func exitCode(err error) int {
+	var ee *exec.ExitError
+	switch {
+	case err == nil:
+		return 0
+	case errors.As(err, &ee):
+		if ws, ok := ee.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+			return 128 + int(ws.Signal())
+		}
+		return ee.ExitCode()
+	}
+	// it never started
+	return 127
+}
+
+// describe describes how a command exited.
+func describe(err error) string {
+	if err == nil {
+		return "exit status 0"
+	}
+	return err.Error()
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/fswatch/fswatch"
+)
+
+// stringList is a flag which may be given several times.
+type stringList []string
+
+func (x *stringList) String() string {
+	return strings.Join(*x, ",")
+}
+
+func (x *stringList) Set(s string) error {
+	*x = append(*x, s)
+	return nil
+}
+
+// watchConfig is what to watch and how, as shared by every command.
+type watchConfig struct {
+	backend    string
+	latency    time.Duration
+	recursive  bool
+	noFollow   bool
+	gitignore  bool
+	ignore     stringList
+	include    stringList
+	exclude    stringList
+	events     string
+	eventTypes []fswatch.EventType
+}
+
+// watchFlags defines the flags of a watchConfig on fs.
+func watchFlags(fs *flag.FlagSet) *watchConfig {
+	c := &watchConfig{}
+	fs.StringVar(&c.backend, "backend", "", "watch with the named `backend`: "+strings.Join(fswatch.Backends(), ", ")+" (default for the platform)")
+	fs.DurationVar(&c.latency, "latency", time.Second/4, "collect events for `duration` before delivering them, 0 delivers them immediately")
+	fs.BoolVar(&c.recursive, "recursive", true, "watch directories recursively; with -recursive=false only the given files are watched")
+	fs.BoolVar(&c.noFollow, "no-follow", false, "watch symlinks themselves instead of their targets")
+	fs.BoolVar(&c.gitignore, "gitignore", false, "ignore paths listed in .gitignore files")
+	fs.Var(&c.ignore, "ignore-file", "ignore paths listed in files with this `name`, in gitignore syntax (repeatable)")
+	fs.Var(&c.include, "include", "only report paths matching the glob `pattern` (repeatable)")
+	fs.Var(&c.exclude, "exclude", "ignore paths matching the glob `pattern` (repeatable)")
+	fs.StringVar(&c.events, "events", "", "only report events of these comma-separated `types`, such as CREATED,DELETED")
+	return c
+}
+
+// options returns the fswatch options of the config, checking the event types.
+func (c *watchConfig) options() (fswatch.Options, error) {
+	o := fswatch.Options{
+		Backend:     c.backend,
+		Latency:     c.latency,
+		Include:     c.include,
+		Exclude:     c.exclude,
+		IgnoreFiles: c.ignore,
+	}
+	if o.Latency == 0 {
+		o.Latency = -1
+	}
+	if c.gitignore {
+		o.IgnoreFiles = append([]string{".gitignore"}, o.IgnoreFiles...)
+	}
+	if c.noFollow {
+		o.Symlinks = fswatch.SymlinksNoFollow
+	}
+
+	c.eventTypes = nil
+	for _, name := range strings.Split(c.events, ",") {
+		name = strings.ToUpper(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		t, ok := fswatch.ParseEventType(name)
+		if !ok {
+			return o, fmt.Errorf("unknown event type %q", name)
+		}
+		c.eventTypes = append(c.eventTypes, t)
+	}
+	return o, nil
+}
+
+// watch watches paths as configured until ctx is done, or any watch ends with
+// an error, which is returned.
+func (c *watchConfig) watch(ctx context.Context, paths []string, obs fswatch.Observer) error {
+	o, err := c.options()
+	if err != nil {
+		return err
+	}
+	x, err := fswatch.NewWithOptions(o)
+	if err != nil {
+		return err
+	}
+	var opts []fswatch.WatchOption
+	if len(c.eventTypes) > 0 {
+		opts = append(opts, fswatch.OnlyEvents(c.eventTypes...))
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var watches []*fswatch.Watch
+	var files []string
+	for _, p := range paths {
+		info, err := os.Stat(p)
+		if err != nil {
+			return err
+		}
+		if !c.recursive || !info.IsDir() {
+			files = append(files, p)
+			continue
+		}
+		w, err := x.RecursivelyContext(ctx, p, obs, opts...)
+		if err != nil {
+			return err
+		}
+		watches = append(watches, w)
+	}
+	if len(files) > 0 {
+		w, err := x.FilesContext(ctx, files, obs, opts...)
+		if err != nil {
+			return err
+		}
+		watches = append(watches, w)
+	}
+
+	// the first watch to end ends them all
+	done := make(chan *fswatch.Watch, len(watches))
+	for _, w := range watches {
+		go func(w *fswatch.Watch) {
+			<-w.Done()
+			done <- w
+		}(w)
+	}
+	w := <-done
+	if err := w.Err(); err != nil && err != ctx.Err() {
+		return err
+	}
+	return nil
+}
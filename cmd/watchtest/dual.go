@@ -37,11 +37,11 @@ func doTwoFileTest(dur time.Duration) {
 	log.Println("Watching two files: ", rname1, rname2)
 
 	expect, obs := checkFileLoop(rname1, rname2)
-	cancel1, err := fswatch.Files([]string{rname1, rname2}, obs)
+	w1, err := fswatch.Files([]string{rname1, rname2}, obs, fswatch.OnlyEvents(fswatch.MODIFIED, fswatch.DELETED))
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer cancel1()
+	defer w1.Cancel()
 
 	expect <- fswatch.MODIFIED
 	f, err = os.OpenFile(rname1, os.O_RDWR, 0666)
@@ -35,6 +35,8 @@ func printObserver(path string, ev fswatch.EventType) error {
 		es = "OTHER"
 		// don't print
 		return nil
+	case fswatch.RENAMED:
+		es = "RENAMED"
 	}
 	log.Println(path, es)
 	return nil
@@ -63,7 +65,6 @@ func checkFileLoop(filenames ...string) (expect chan fswatch.EventType, obs fswa
 
 	go func() {
 		for {
-			var rc fswatch.EventType
 			ev := <-expect
 			//log.Println("Expect", ev)
 			if ev == EventSentinal {
@@ -72,14 +73,7 @@ func checkFileLoop(filenames ...string) (expect chan fswatch.EventType, obs fswa
 				log.Println("Done watching file. Tests passed!")
 				return
 			}
-			for rc = range recvr {
-				if ev == rc {
-					break
-				}
-				if rc == fswatch.OTHER {
-					continue
-				}
-			}
+			rc := <-recvr
 			if ev == rc {
 				continue
 			}
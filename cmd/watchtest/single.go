@@ -30,11 +30,11 @@ func doSingleFileTest(dur time.Duration) {
 	log.Println("Watching one file: ", rname1)
 
 	expect, obs := checkFileLoop(rname1)
-	cancel1, err := fswatch.File(rname1, obs)
+	w1, err := fswatch.File(rname1, obs, fswatch.OnlyEvents(fswatch.MODIFIED, fswatch.DELETED))
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer cancel1()
+	defer w1.Cancel()
 
 	expect <- fswatch.MODIFIED
 	f, err = os.OpenFile(rname1, os.O_RDWR, 0666)
@@ -0,0 +1,46 @@
+package fswatch
+
+import "context"
+
+// FileContext is like File, but the watch also ends when ctx is done,
+// in which case its Err method returns ctx.Err().
+func FileContext(ctx context.Context, path string, obs Observer, opts ...WatchOption) (w *Watch, err error) {
+	return withContext(ctx, func() (*Watch, error) {
+		return wrapFiles(impl, []string{path}, obs, opts...)
+	})
+}
+
+// FilesContext is like Files, but the watch also ends when ctx is done,
+// in which case its Err method returns ctx.Err().
+func FilesContext(ctx context.Context, paths []string, obs Observer, opts ...WatchOption) (w *Watch, err error) {
+	return withContext(ctx, func() (*Watch, error) {
+		return wrapFiles(impl, paths, obs, opts...)
+	})
+}
+
+// RecursivelyContext is like Recursively, but the watch also ends when ctx is done,
+// in which case its Err method returns ctx.Err().
+func RecursivelyContext(ctx context.Context, path string, obs Observer, opts ...WatchOption) (w *Watch, err error) {
+	return withContext(ctx, func() (*Watch, error) {
+		return wrapRecursively(impl, path, obs, opts...)
+	})
+}
+
+// withContext starts a watch and ends it with ctx.Err() once ctx is done.
+func withContext(ctx context.Context, start func() (*Watch, error)) (*Watch, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	w, err := start()
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		select {
+		case <-ctx.Done():
+			w.w.Close(ctx.Err())
+		case <-w.Done():
+		}
+	}()
+	return w, nil
+}
@@ -0,0 +1,567 @@
+// Package daemon serves watches to other processes over a socket, so that
+// programs in any language can share a single watch of each root, instead
+// of each watching the same trees itself.
+//
+// The protocol is modeled on Watchman's: each request is a JSON array on a
+// line of its own, and is answered by a JSON object on a line of its own, or
+// one with an "error" member if it failed. The requests are:
+//
+//	["watch-project", path]
+//	    Watches path recursively, unless a watched root contains it already,
+//	    and answers {"watch": root, "relative_path": path below the root}.
+//	["clock", root]
+//	    Answers {"clock": clock}, the current clock of the root.
+//	["query", root, params]
+//	    Answers a Result with the files changed since params.since.
+//	["subscribe", root, name, params]
+//	    Answers {"subscribe": name, "clock": clock}, and then sends the changes
+//	    matching params as they happen, a message for each batch of events
+//	    delivered by the system: {"subscription": name, "root": root,
+//	    "clock": clock, "files": [...], "unilateral": true}. With params.since,
+//	    the changes since that clock are sent first, or a fresh instance
+//	    without any files if they are not known.
+//	["unsubscribe", root, name]
+//	    Ends a subscription, and answers {"unsubscribe": name, "deleted": true}.
+//
+// The params of query and subscribe are an object with the members "since",
+// a clock; "include" and "exclude", lists of glob patterns as for
+// fswatch.Include and fswatch.Exclude; and "relative_root", a path below the
+// root to which the files are restricted, and their names relative. All of
+// them are optional.
+//
+// A clock is an opaque string naming a point in the history of a watch of a
+// root. Changes since a clock can't be answered precisely if the clock is of
+// an earlier watch of the root, or if events were lost since, in which case
+// the answer is marked as a fresh instance and lists every file instead. If a
+// client is too slow to read the changes of a subscription, they are dropped,
+// and its next message is marked as a fresh instance, so it can query the
+// changes it missed. A root is watched until the Server is closed, or the
+// watch fails, in which case its subscriptions receive a message with
+// "canceled": true and the error.
+package daemon
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/fswatch/fswatch"
+)
+
+// ErrServerClosed is returned by Serve once the Server is closed.
+var ErrServerClosed = errors.New("daemon: server closed")
+
+// queueSize is how many messages are queued for a client which is slow to read them.
+const queueSize = 1024
+
+// Server serves watches to clients.
+type Server struct {
+	// WatchOptions are the options of every watch of a root.
+	WatchOptions []fswatch.WatchOption
+
+	x fswatch.Interface
+
+	mu        sync.Mutex
+	closed    bool
+	listeners map[net.Listener]bool
+	conns     map[*conn]bool
+	roots     map[string]*root // by path
+}
+
+// NewServer returns a Server watching roots with x.
+func NewServer(x fswatch.Interface) *Server {
+	return &Server{
+		x:         x,
+		listeners: make(map[net.Listener]bool),
+		conns:     make(map[*conn]bool),
+		roots:     make(map[string]*root),
+	}
+}
+
+// Serve accepts connections on l, and serves each in a goroutine of its own.
+// It returns ErrServerClosed once the Server is closed, or the error that
+// stopped it from accepting connections.
+func (s *Server) Serve(l net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return ErrServerClosed
+	}
+	s.listeners[l] = true
+	s.mu.Unlock()
+
+	for {
+		nc, err := l.Accept()
+		if err != nil {
+			s.mu.Lock()
+			delete(s.listeners, l)
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			return err
+		}
+
+		c := &conn{
+			s:    s,
+			nc:   nc,
+			out:  make(chan interface{}, queueSize),
+			done: make(chan struct{}),
+			subs: make(map[subKey]*subscription),
+		}
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			nc.Close()
+			return ErrServerClosed
+		}
+		s.conns[c] = true
+		s.mu.Unlock()
+		go c.serve()
+	}
+}
+
+// Close stops the listeners, closes all connections, and ends all watches.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	listeners, conns, roots := s.listeners, s.conns, s.roots
+	s.listeners, s.conns, s.roots = nil, nil, nil
+	s.mu.Unlock()
+
+	for l := range listeners {
+		l.Close()
+	}
+	for c := range conns {
+		c.close()
+	}
+	for _, r := range roots {
+		select {
+		case <-r.started:
+			r.w.Cancel()
+		default:
+			// cancelled once started, by watchProject
+		}
+	}
+	return nil
+}
+
+// watchProject returns the watched root containing path, watching path
+// if there is none, and the path relative to the root.
+func (s *Server) watchProject(path string) (*root, string, error) {
+	path, err := resolve(path)
+	if err != nil {
+		return nil, "", err
+	}
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil, "", ErrServerClosed
+	}
+	var best *root
+	for p, r := range s.roots {
+		if path == p || strings.HasPrefix(path, p+string(filepath.Separator)) {
+			if best == nil || len(p) > len(best.path) {
+				best = r
+			}
+		}
+	}
+	if best != nil {
+		s.mu.Unlock()
+		if err := best.wait(); err != nil {
+			return nil, "", err
+		}
+		rel := best.rel(path)
+		if rel == "." {
+			rel = ""
+		}
+		return best, rel, nil
+	}
+
+	// The root is added before it is watched, which walks the whole tree,
+	// so that other requests for it wait for the watch instead of starting
+	// another one, while the server is not held up.
+	r := newRoot(path)
+	s.roots[path] = r
+	s.mu.Unlock()
+
+	r.w, r.err = s.x.Recursively(path, r, s.WatchOptions...)
+	close(r.started)
+
+	s.mu.Lock()
+	closed := s.closed
+	if r.err != nil && s.roots[path] == r {
+		delete(s.roots, path)
+	}
+	s.mu.Unlock()
+	if r.err != nil {
+		return nil, "", r.err
+	}
+	if closed {
+		r.w.Cancel()
+		return nil, "", ErrServerClosed
+	}
+	go s.ended(r)
+	return r, "", nil
+}
+
+// resolve returns the absolute path of a root, with symlinks evaluated,
+// as it is watched.
+func resolve(path string) (string, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	return filepath.EvalSymlinks(path)
+}
+
+// root returns the watched root at path, once it is watched.
+func (s *Server) root(path string) (*root, error) {
+	p, err := resolve(path)
+	if err != nil {
+		return nil, fmt.Errorf("daemon: %s is not watched", path)
+	}
+	s.mu.Lock()
+	r, ok := s.roots[p]
+	s.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("daemon: %s is not watched", path)
+	}
+	if err := r.wait(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// ended forgets a root once its watch ended, and cancels its subscriptions.
+func (s *Server) ended(r *root) {
+	<-r.w.Done()
+
+	s.mu.Lock()
+	if s.roots[r.path] == r {
+		delete(s.roots, r.path)
+	}
+	s.mu.Unlock()
+
+	msg := "watch ended"
+	if err := r.w.Err(); err != nil {
+		msg = err.Error()
+	}
+	r.mu.Lock()
+	subs := r.subs
+	r.subs = nil
+	r.mu.Unlock()
+	for sub := range subs {
+		sub.c.unsubscribed(sub)
+		sub.c.send(&canceled{Subscription: sub.name, Root: r.path, Canceled: true, Error: msg, Unilateral: true})
+	}
+}
+
+// conn is a connection of a client.
+type conn struct {
+	s    *Server
+	nc   net.Conn
+	out  chan interface{} // messages to send
+	once sync.Once
+	done chan struct{} // closed once the connection is closed
+
+	mu   sync.Mutex
+	subs map[subKey]*subscription
+}
+
+type subKey struct {
+	root string
+	name string
+}
+
+// subscription is a subscription of a client to the changes of a root.
+type subscription struct {
+	name string
+	r    *root
+	c    *conn
+	p    *params
+	lost bool // messages were dropped, guarded by the lock of the root
+}
+
+// Messages sent to clients.
+type (
+	errorReply struct {
+		Error string `json:"error"`
+	}
+	watchReply struct {
+		Watch        string `json:"watch"`
+		RelativePath string `json:"relative_path,omitempty"`
+	}
+	clockReply struct {
+		Clock string `json:"clock"`
+	}
+	subscribeReply struct {
+		Subscribe string `json:"subscribe"`
+		Clock     string `json:"clock"`
+	}
+	unsubscribeReply struct {
+		Unsubscribe string `json:"unsubscribe"`
+		Deleted     bool   `json:"deleted"`
+	}
+	notification struct {
+		Subscription    string `json:"subscription"`
+		Root            string `json:"root"`
+		Clock           string `json:"clock"`
+		IsFreshInstance bool   `json:"is_fresh_instance,omitempty"`
+		Files           []File `json:"files"`
+		Unilateral      bool   `json:"unilateral"`
+	}
+	canceled struct {
+		Subscription string `json:"subscription"`
+		Root         string `json:"root"`
+		Canceled     bool   `json:"canceled"`
+		Error        string `json:"error"`
+		Unilateral   bool   `json:"unilateral"`
+	}
+)
+
+// serve reads and answers the requests of the client until it disconnects.
+func (c *conn) serve() {
+	defer c.close()
+	go c.write()
+
+	sc := bufio.NewScanner(c.nc)
+	sc.Buffer(nil, 1<<20)
+	for sc.Scan() {
+		if len(sc.Bytes()) == 0 {
+			continue
+		}
+		reply := c.handle(sc.Bytes())
+		if reply != nil && !c.send(reply) {
+			return
+		}
+	}
+}
+
+// write sends the queued messages to the client.
+func (c *conn) write() {
+	enc := json.NewEncoder(c.nc)
+	for {
+		select {
+		case msg := <-c.out:
+			if err := enc.Encode(msg); err != nil {
+				c.close()
+				return
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// send queues a message, reporting false if the queue is full or the
+// connection is closed.
+func (c *conn) send(msg interface{}) bool {
+	select {
+	case <-c.done:
+		return false
+	default:
+	}
+	select {
+	case c.out <- msg:
+		return true
+	default:
+		return false
+	}
+}
+
+// close closes the connection, and ends its subscriptions.
+func (c *conn) close() {
+	c.once.Do(func() {
+		close(c.done)
+		c.nc.Close()
+
+		c.s.mu.Lock()
+		delete(c.s.conns, c)
+		c.s.mu.Unlock()
+
+		c.mu.Lock()
+		subs := c.subs
+		c.subs = nil
+		c.mu.Unlock()
+		for _, sub := range subs {
+			sub.r.unsubscribe(sub)
+		}
+	})
+}
+
+// handle answers a request, returning the reply unless it was sent already.
+func (c *conn) handle(line []byte) interface{} {
+	var req []json.RawMessage
+	var cmd string
+	if err := json.Unmarshal(line, &req); err != nil || len(req) == 0 {
+		return &errorReply{"daemon: a request must be a JSON array"}
+	}
+	if err := json.Unmarshal(req[0], &cmd); err != nil {
+		return &errorReply{"daemon: the command must be a string"}
+	}
+	reply, err := c.command(cmd, req[1:])
+	if err != nil {
+		return &errorReply{err.Error()}
+	}
+	return reply
+}
+
+// command runs a command with its arguments.
+func (c *conn) command(cmd string, args []json.RawMessage) (interface{}, error) {
+	var (
+		path, name string
+		p          params
+	)
+	switch cmd {
+	case "watch-project":
+		if err := parseArgs(cmd, args, &path); err != nil {
+			return nil, err
+		}
+		r, rel, err := c.s.watchProject(path)
+		if err != nil {
+			return nil, err
+		}
+		return &watchReply{Watch: r.path, RelativePath: rel}, nil
+
+	case "clock":
+		if err := parseArgs(cmd, args, &path); err != nil {
+			return nil, err
+		}
+		r, err := c.s.root(path)
+		if err != nil {
+			return nil, err
+		}
+		return &clockReply{Clock: string(r.w.Clock())}, nil
+
+	case "query":
+		if err := parseArgs(cmd, args, &path, &p); err != nil {
+			return nil, err
+		}
+		r, err := c.s.root(path)
+		if err != nil {
+			return nil, err
+		}
+		return r.query(&p)
+
+	case "subscribe":
+		if err := parseArgs(cmd, args, &path, &name, &p); err != nil {
+			return nil, err
+		}
+		r, err := c.s.root(path)
+		if err != nil {
+			return nil, err
+		}
+		return c.subscribe(r, name, &p)
+
+	case "unsubscribe":
+		if err := parseArgs(cmd, args, &path, &name); err != nil {
+			return nil, err
+		}
+		if p, err := resolve(path); err == nil {
+			path = p
+		}
+		c.mu.Lock()
+		sub := c.subs[subKey{path, name}]
+		delete(c.subs, subKey{path, name})
+		c.mu.Unlock()
+		if sub != nil {
+			sub.r.unsubscribe(sub)
+		}
+		return &unsubscribeReply{Unsubscribe: name, Deleted: sub != nil}, nil
+	}
+	return nil, fmt.Errorf("daemon: unknown command %q", cmd)
+}
+
+// parseArgs parses the arguments of a command. The last one may be left
+// out if it is params.
+func parseArgs(cmd string, args []json.RawMessage, vals ...interface{}) error {
+	n := len(vals)
+	if _, ok := vals[n-1].(*params); ok && len(args) == n-1 {
+		n--
+	}
+	if len(args) != n {
+		return fmt.Errorf("daemon: %s takes %d arguments", cmd, len(vals))
+	}
+	for i, arg := range args {
+		if err := json.Unmarshal(arg, vals[i]); err != nil {
+			return fmt.Errorf("daemon: %s: argument %d: %w", cmd, i+1, err)
+		}
+	}
+	if p, ok := vals[len(vals)-1].(*params); ok {
+		return p.compile()
+	}
+	return nil
+}
+
+// subscribe subscribes the client to the changes of a root. The reply is
+// sent here, so that it comes before any changes.
+func (c *conn) subscribe(r *root, name string, p *params) (interface{}, error) {
+	sub := &subscription{name: name, r: r, c: c, p: p}
+	key := subKey{r.path, name}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.subs == nil {
+		return nil, ErrServerClosed
+	}
+	if c.subs[key] != nil {
+		return nil, fmt.Errorf("daemon: subscription %q exists already", name)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.subs == nil {
+		return nil, fmt.Errorf("daemon: %s is no longer watched", r.path)
+	}
+	if !c.send(&subscribeReply{Subscribe: name, Clock: string(r.w.Clock())}) {
+		return nil, errors.New("daemon: client is too slow")
+	}
+	c.subs[key] = sub
+	r.subs[sub] = true
+	if p.Since != "" {
+		res := r.changes(p)
+		sub.notify(res.Clock, res.Files, res.IsFreshInstance)
+	}
+	return nil, nil
+}
+
+// notify queues a message with changes, or marks the subscription as having
+// lost them if the client is too slow. It is called with the lock of the root.
+func (sub *subscription) notify(clock string, files []File, fresh bool) {
+	if files == nil {
+		files = []File{}
+	}
+	msg := &notification{
+		Subscription:    sub.name,
+		Root:            sub.r.path,
+		Clock:           clock,
+		IsFreshInstance: fresh || sub.lost,
+		Files:           files,
+		Unilateral:      true,
+	}
+	sub.lost = !sub.c.send(msg)
+}
+
+// unsubscribed forgets a subscription whose root is no longer watched.
+func (c *conn) unsubscribed(sub *subscription) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.subs[subKey{sub.r.path, sub.name}] == sub {
+		delete(c.subs, subKey{sub.r.path, sub.name})
+	}
+}
+
+// unsubscribe ends a subscription.
+func (r *root) unsubscribe(sub *subscription) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.subs, sub)
+	delete(r.pending, sub)
+}
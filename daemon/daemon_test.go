@@ -0,0 +1,220 @@
+package daemon
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/fswatch/fswatch"
+	"github.com/fswatch/fswatch/fswatchtest"
+)
+
+// reply is any message sent to a client.
+type reply struct {
+	Error           string `json:"error"`
+	Watch           string `json:"watch"`
+	RelativePath    string `json:"relative_path"`
+	Clock           string `json:"clock"`
+	Subscribe       string `json:"subscribe"`
+	Subscription    string `json:"subscription"`
+	Unsubscribe     string `json:"unsubscribe"`
+	Deleted         bool   `json:"deleted"`
+	IsFreshInstance bool   `json:"is_fresh_instance"`
+	Files           []File `json:"files"`
+	Canceled        bool   `json:"canceled"`
+}
+
+// client is a connection to a Server.
+type client struct {
+	t  *testing.T
+	nc net.Conn
+	r  *bufio.Reader
+}
+
+// serve serves a Fake watching a real directory, returning the directory,
+// the Fake, and a connected client.
+func serve(t *testing.T) (string, *fswatchtest.Fake, *client) {
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := fswatchtest.New(fswatch.Options{})
+	s := NewServer(f)
+	l, err := net.Listen("unix", filepath.Join(t.TempDir(), "sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(l)
+	t.Cleanup(func() { s.Close() })
+
+	nc, err := net.Dial("unix", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	nc.SetDeadline(time.Now().Add(5 * time.Second))
+	return dir, f, &client{t: t, nc: nc, r: bufio.NewReader(nc)}
+}
+
+// call sends a request, and returns the next message.
+func (c *client) call(req ...interface{}) *reply {
+	c.t.Helper()
+	if err := json.NewEncoder(c.nc).Encode(req); err != nil {
+		c.t.Fatal(err)
+	}
+	return c.read()
+}
+
+// read returns the next message.
+func (c *client) read() *reply {
+	c.t.Helper()
+	line, err := c.r.ReadBytes('\n')
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	var r reply
+	if err := json.Unmarshal(line, &r); err != nil {
+		c.t.Fatalf("bad message %q: %v", line, err)
+	}
+	return &r
+}
+
+func TestWatchProject(t *testing.T) {
+	dir, f, c := serve(t)
+	os.Mkdir(filepath.Join(dir, "sub"), 0755)
+
+	if r := c.call("watch-project", dir); r.Error != "" || r.Watch != dir || r.RelativePath != "" {
+		t.Fatalf("watch-project = %+v", r)
+	}
+	if r := c.call("watch-project", filepath.Join(dir, "sub")); r.Watch != dir || r.RelativePath != "sub" {
+		t.Errorf("watch-project of a folder below the root = %+v", r)
+	}
+	if n := f.Watches(); n != 1 {
+		t.Errorf("%d watches, want 1", n)
+	}
+
+	// the root is found by any path resolving to it
+	link := filepath.Join(t.TempDir(), "link")
+	if err := os.Symlink(dir, link); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []string{dir, link, dir + "/sub/.."} {
+		if r := c.call("clock", p); r.Error != "" || r.Clock == "" {
+			t.Errorf("clock of %s = %+v", p, r)
+		}
+	}
+}
+
+func TestErrors(t *testing.T) {
+	dir, _, c := serve(t)
+	for _, req := range [][]interface{}{
+		{"bogus"},
+		{"clock", dir},
+		{"clock"},
+		{"watch-project", filepath.Join(dir, "missing")},
+		{"query", dir, map[string]interface{}{"include": []string{"["}}},
+		{42},
+	} {
+		if r := c.call(req...); r.Error == "" {
+			t.Errorf("%v succeeded", req)
+		}
+	}
+	if _, err := c.nc.Write([]byte("{}\n")); err != nil {
+		t.Fatal(err)
+	}
+	if r := c.read(); r.Error == "" {
+		t.Errorf("a request which is not an array succeeded")
+	}
+}
+
+func TestQuery(t *testing.T) {
+	dir, f, c := serve(t)
+	os.WriteFile(filepath.Join(dir, "a.go"), nil, 0644)
+	os.WriteFile(filepath.Join(dir, "b.txt"), nil, 0644)
+	c.call("watch-project", dir)
+	clock := c.call("clock", dir).Clock
+
+	f.Emit(filepath.Join(dir, "c.go"), fswatch.CREATED)
+	f.Emit(filepath.Join(dir, "b.txt"), fswatch.MODIFIED)
+	f.Emit(filepath.Join(dir, "a.go"), fswatch.DELETED)
+
+	r := c.call("query", dir, map[string]interface{}{"since": clock, "include": []string{"*.go"}})
+	want := []File{
+		{Name: "a.go", Exists: false, Type: "DELETED"},
+		{Name: "c.go", Exists: true, Type: "CREATED"},
+	}
+	if r.IsFreshInstance || !reflect.DeepEqual(r.Files, want) || r.Clock == clock {
+		t.Errorf("query since %s = %+v, want %v", clock, r, want)
+	}
+
+	// without a clock, the files which exist are listed
+	r = c.call("query", dir, map[string]interface{}{"include": []string{"*.go"}})
+	want = []File{{Name: "a.go", Exists: true}}
+	if !r.IsFreshInstance || !reflect.DeepEqual(r.Files, want) {
+		t.Errorf("query without a clock = %+v, want %v", r, want)
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	dir, f, c := serve(t)
+	c.call("watch-project", dir)
+	if r := c.call("subscribe", dir, "go", map[string]interface{}{"include": []string{"*.go"}}); r.Subscribe != "go" || r.Clock == "" {
+		t.Fatalf("subscribe = %+v", r)
+	}
+	if r := c.call("subscribe", dir, "go"); r.Error == "" {
+		t.Errorf("subscribing twice with the same name succeeded")
+	}
+
+	// a batch of events is sent as a single message
+	f.EmitBatch(
+		fswatch.Event{Path: filepath.Join(dir, "a.go"), Type: fswatch.CREATED},
+		fswatch.Event{Path: filepath.Join(dir, "b.txt"), Type: fswatch.CREATED},
+		fswatch.Event{Path: filepath.Join(dir, "c.go"), Type: fswatch.MODIFIED},
+	)
+	f.Emit(filepath.Join(dir, "d.txt"), fswatch.CREATED)
+	f.Emit(filepath.Join(dir, "e.go"), fswatch.DELETED)
+	r := c.read()
+	want := []File{
+		{Name: "a.go", Exists: true, Type: "CREATED"},
+		{Name: "c.go", Exists: true, Type: "MODIFIED"},
+	}
+	if r.Subscription != "go" || r.IsFreshInstance || !reflect.DeepEqual(r.Files, want) {
+		t.Errorf("notification = %+v, want %v", r, want)
+	}
+	r = c.read()
+	want = []File{{Name: "e.go", Exists: false, Type: "DELETED"}}
+	if !reflect.DeepEqual(r.Files, want) {
+		t.Errorf("notification = %+v, want %v", r, want)
+	}
+
+	// lost events make the next message a fresh instance
+	f.EmitBatch(
+		fswatch.Event{Path: filepath.Join(dir, "f.go"), Type: fswatch.CREATED},
+		fswatch.Event{Path: dir, Type: fswatch.OVERFLOW},
+	)
+	if r := c.read(); !r.IsFreshInstance || len(r.Files) != 0 {
+		t.Errorf("notification after an overflow = %+v", r)
+	}
+
+	if r := c.call("unsubscribe", dir, "go"); !r.Deleted {
+		t.Errorf("unsubscribe = %+v", r)
+	}
+	f.Emit(filepath.Join(dir, "g.go"), fswatch.CREATED)
+	if r := c.call("unsubscribe", dir, "go"); r.Unsubscribe != "go" || r.Deleted {
+		t.Errorf("unsubscribe = %+v after the subscription ended", r)
+	}
+}
+
+func TestCanceled(t *testing.T) {
+	dir, f, c := serve(t)
+	c.call("watch-project", dir)
+	c.call("subscribe", dir, "all")
+	f.Fail(os.ErrClosed)
+	if r := c.read(); !r.Canceled || r.Subscription != "all" || r.Error == "" {
+		t.Errorf("message once the watch failed = %+v", r)
+	}
+}
@@ -0,0 +1,232 @@
+package daemon
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/fswatch/fswatch"
+	"github.com/fswatch/fswatch/internal/filter"
+)
+
+// root is a watched root, shared by all clients. Its clock is the clock of
+// its watch.
+type root struct {
+	path    string // absolute, with symlinks evaluated
+	w       *fswatch.Watch
+	err     error         // if the watch failed to start
+	started chan struct{} // closed once w or err is set
+
+	// mu is held while notifying, and while subscribing, to keep them in
+	// order. An event is counted by the watch before it is observed, so a
+	// subscription may be notified of a change its clock includes already.
+	mu       sync.Mutex
+	subs     map[*subscription]bool
+	pending  map[*subscription][]File // changes of the current batch
+	overflow bool                     // events of the current batch were lost
+}
+
+// newRoot returns a root for path, which is watched once started.
+func newRoot(path string) *root {
+	return &root{
+		path:    path,
+		started: make(chan struct{}),
+		subs:    make(map[*subscription]bool),
+		pending: make(map[*subscription][]File),
+	}
+}
+
+// wait waits until the root is watched, returning
+// the error if the watch failed to start.
+func (r *root) wait() error {
+	<-r.started
+	return r.err
+}
+
+// Observe adds an event to the changes of the subscriptions it matches,
+// which are notified of them once the batch of the event is flushed.
+func (r *root) Observe(ev fswatch.Event) error {
+	<-r.started
+	f := File{Name: r.rel(ev.Path), Exists: ev.Type != fswatch.DELETED, Type: ev.Type.String()}
+	if ev.OldPath != "" {
+		f.OldName = r.rel(ev.OldPath)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if ev.Type == fswatch.OVERFLOW {
+		r.overflow = true
+		return nil
+	}
+	for sub := range r.subs {
+		if sub.p.match(r, f.Name) || (f.OldName != "" && sub.p.match(r, f.OldName)) {
+			r.pending[sub] = append(r.pending[sub], sub.p.file(f))
+		}
+	}
+	return nil
+}
+
+// Flush notifies the subscriptions of the changes of a batch, with a single
+// message each, or of a fresh instance if events were lost.
+func (r *root) Flush() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	clock := string(r.w.Clock())
+	for sub := range r.subs {
+		if r.overflow {
+			sub.notify(clock, nil, true)
+		} else if files := r.pending[sub]; len(files) > 0 {
+			sub.notify(clock, files, false)
+		}
+	}
+	r.pending = make(map[*subscription][]File)
+	r.overflow = false
+	return nil
+}
+
+// rel returns p relative to the root, with '/' separators.
+func (r *root) rel(p string) string {
+	if rel, err := filepath.Rel(r.path, p); err == nil {
+		p = rel
+	}
+	return filepath.ToSlash(p)
+}
+
+// Result is the answer to a query.
+type Result struct {
+	Clock string `json:"clock"`
+
+	// IsFreshInstance is set if the changes since the clock of the query are
+	// not known, because it is of another watch of the root, or events were
+	// lost. Files then lists every file which exists.
+	IsFreshInstance bool   `json:"is_fresh_instance"`
+	Files           []File `json:"files"`
+}
+
+// File is a changed file in a Result or a subscription.
+type File struct {
+	Name    string `json:"name"` // relative to the root
+	Exists  bool   `json:"exists"`
+	Type    string `json:"type,omitempty"`     // of the last event
+	OldName string `json:"old_name,omitempty"` // for a RENAMED event
+}
+
+// query returns the files changed since the clock of p, sorted by name.
+func (r *root) query(p *params) (*Result, error) {
+	res := r.changes(p)
+	if res.IsFreshInstance {
+		if err := r.walk(p, res); err != nil {
+			return nil, err
+		}
+		sortFiles(res.Files)
+	}
+	return res, nil
+}
+
+// changes returns the files changed since the clock of p, sorted by name,
+// or a fresh instance without any files if they are not known.
+func (r *root) changes(p *params) *Result {
+	changes := r.w.ChangedSince(fswatch.Clock(p.Since))
+	res := &Result{Clock: string(changes.Clock), IsFreshInstance: changes.FreshInstance}
+	for _, c := range changes.Changes {
+		name := r.rel(c.Path)
+		if p.match(r, name) {
+			res.Files = append(res.Files, p.file(File{Name: name, Exists: c.Exists, Type: c.Type.String()}))
+		}
+	}
+	sortFiles(res.Files)
+	return res
+}
+
+func sortFiles(files []File) {
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Name < files[j].Name
+	})
+}
+
+// walk adds every file below the root matched by p to a result.
+func (r *root) walk(p *params, res *Result) error {
+	start := filepath.Join(r.path, filepath.FromSlash(p.RelativeRoot))
+	return filepath.Walk(start, func(fp string, fi os.FileInfo, err error) error {
+		if err != nil {
+			if fp == start {
+				return err
+			}
+			return nil
+		}
+		if fp == start {
+			return nil
+		}
+		name := r.rel(fp)
+		if fi.IsDir() && p.filter.Skip(p.file(File{Name: name}).Name) {
+			return filepath.SkipDir
+		}
+		if p.match(r, name) {
+			res.Files = append(res.Files, p.file(File{Name: name, Exists: true}))
+		}
+		return nil
+	})
+}
+
+// params are the parameters of a query or subscription.
+type params struct {
+	Since        string   `json:"since,omitempty"`         // a clock
+	Include      []string `json:"include,omitempty"`       // glob patterns as for fswatch.Include
+	Exclude      []string `json:"exclude,omitempty"`       // glob patterns as for fswatch.Exclude
+	RelativeRoot string   `json:"relative_root,omitempty"` // only files below it, with names relative to it
+
+	filter *filter.Filter
+}
+
+// compile checks the parameters, and prepares them for matching.
+func (p *params) compile() error {
+	if p.RelativeRoot != "" {
+		p.RelativeRoot = path.Clean(filepath.ToSlash(p.RelativeRoot))
+		if p.RelativeRoot == "." {
+			p.RelativeRoot = ""
+		}
+	}
+	f, err := filter.New(p.Include, p.Exclude)
+	p.filter = f
+	return err
+}
+
+// match reports whether a path relative to the root is of interest.
+func (p *params) match(r *root, name string) bool {
+	isDir := false
+	if p.filter.NeedsDir() {
+		if fi, err := os.Lstat(filepath.Join(r.path, filepath.FromSlash(name))); err == nil {
+			isDir = fi.IsDir()
+		}
+	}
+	if p.RelativeRoot != "" {
+		if !strings.HasPrefix(name, p.RelativeRoot+"/") {
+			return false
+		}
+		name = name[len(p.RelativeRoot)+1:]
+	}
+	return p.filter.Match(name, isDir)
+}
+
+// file returns f with its names relative to the relative root. An old name
+// outside of the relative root is left out, and a file renamed out of the
+// relative root is returned with its old name, as it no longer exists there.
+func (p *params) file(f File) File {
+	if p.RelativeRoot == "" {
+		return f
+	}
+	prefix := p.RelativeRoot + "/"
+	if !strings.HasPrefix(f.Name, prefix) {
+		f.Name, f.OldName, f.Exists = f.OldName, "", false
+	}
+	f.Name = strings.TrimPrefix(f.Name, prefix)
+	if strings.HasPrefix(f.OldName, prefix) {
+		f.OldName = f.OldName[len(prefix):]
+	} else {
+		f.OldName = ""
+	}
+	return f
+}
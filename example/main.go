@@ -26,23 +26,23 @@ func main() {
 			log.Fatal(err)
 		}
 		if info.IsDir() {
-			cancel, err := fswatch.Recursively(fn, fswatch.AsObserver(observer))
+			w, err := fswatch.Recursively(fn, fswatch.AsObserver(observer))
 			if err != nil {
 				log.Fatal(err)
 			}
 			log.Println("recursively watching: ", fn)
-			defer cancel()
+			defer w.Cancel()
 			continue
 		}
 		fileBatch = append(fileBatch, fn)
 	}
 	if len(fileBatch) > 0 {
-		cancel, err := fswatch.Files(fileBatch, fswatch.AsObserver(observer))
+		w, err := fswatch.Files(fileBatch, fswatch.AsObserver(observer))
 		if err != nil {
 			log.Fatal(err)
 		}
 		log.Println("batch watching: ", fileBatch)
-		defer cancel()
+		defer w.Cancel()
 	}
 
 	<-tc
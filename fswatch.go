@@ -2,8 +2,10 @@
 package fswatch
 
 import (
+	"context"
+	"fmt"
+
 	"github.com/fswatch/fswatch/internal"
-	"github.com/fswatch/fswatch/internal/poller"
 )
 
 // EventType is the type of events generated by a Watcher.
@@ -15,25 +17,86 @@ const (
 	DELETED  = EventType(internal.DELETED)  // something was deleted
 	MODIFIED = EventType(internal.MODIFIED) // contents were modified
 	OTHER    = EventType(internal.OTHER)    // something else (metadata?) was modified
+	RENAMED  = EventType(internal.RENAMED)  // something was renamed, see Event.OldPath
+	OVERFLOW = EventType(internal.OVERFLOW) // events were lost, the watched paths should be rescanned
 )
 
-const OptionGenericPoller = "-generic-poller-"
+var eventNames = [...]string{
+	NOTHING:  "NOTHING",
+	CREATED:  "CREATED",
+	DELETED:  "DELETED",
+	MODIFIED: "MODIFIED",
+	OTHER:    "OTHER",
+	RENAMED:  "RENAMED",
+	OVERFLOW: "OVERFLOW",
+}
 
-func New(opts map[string]interface{}) Interface {
-	if opts != nil {
-		if _, ok := opts[OptionGenericPoller]; ok {
-			return &wrap{
-				w: watcher(poller.New(opts)),
-			}
+// String returns the name of the event type, such as "CREATED".
+func (t EventType) String() string {
+	if t >= 0 && int(t) < len(eventNames) {
+		return eventNames[t]
+	}
+	return fmt.Sprintf("EventType(%d)", int(t))
+}
+
+// ParseEventType returns the event type named s, as returned by String,
+// such as "CREATED". It reports false for unknown names, and for NOTHING,
+// which is not the type of any event.
+func ParseEventType(s string) (EventType, bool) {
+	for t := CREATED; t <= OVERFLOW; t++ {
+		if eventNames[t] == s {
+			return t, true
 		}
 	}
+	return 0, false
+}
 
-	return &wrap{
-		w: newImpl(opts),
+const OptionGenericPoller = "-generic-poller-"
+
+// OptionHash (of type bool) makes the generic poller compare the contents of
+// files, so that MODIFIED is only observed when their bytes actually changed,
+// and a change to just the mod time is observed as OTHER. Files larger than
+// OptionHashLimit (of type int64, 16MiB by default) are compared by size
+// and mod time instead.
+const (
+	OptionHash      = "hash"
+	OptionHashLimit = "hash-limit"
+)
+
+// OptionRescan (of type bool) makes a watch rescan its paths after an
+// OVERFLOW event, and report whatever changed as CREATED, DELETED, MODIFIED
+// or OTHER events. It is only supported on Linux.
+const OptionRescan = "rescan"
+
+// OptionFanotify (of type bool) makes recursive watches use a single fanotify
+// mark for the whole filesystem of the watched path, instead of an inotify
+// watch for each directory, which scales to trees with millions of
+// directories. It needs Linux 5.9 and the CAP_SYS_ADMIN and
+// CAP_DAC_READ_SEARCH capabilities; without them, inotify is used instead.
+// It is only supported on Linux, and OptionRescan does not apply to it.
+const OptionFanotify = "fanotify"
+
+// New returns an Interface configured by the map form of options, using
+// the Option constants as keys. Unknown keys, and options which are invalid
+// or not supported by the backend, such as OptionHash without
+// OptionGenericPoller, are ignored. It panics if an option has the wrong
+// type; use ParseOptions and NewWithOptions to handle invalid options as
+// errors.
+func New(opts map[string]interface{}) Interface {
+	o, err := parseOptions(opts, false)
+	if err != nil {
+		panic(err)
 	}
+	x, err := NewWithOptions(o)
+	if err != nil {
+		panic(err)
+	}
+	return x
 }
 
 // Interface describes the features of a Filesystem Watch implementation.
+// It is safe for concurrent use, and any number of watches may be active at
+// once, each with its own observer and Watch handle.
 type Interface interface {
 	// File watches a single file, calling the observer with any events.
 	// With a file, the only Events possible are:
@@ -42,24 +105,45 @@ type Interface interface {
 	//     permissions, access time, link count, etc.
 	//   - DELETED indicates that the watched file was removed.
 	//     No further events will be generated for the file.
-	File(path string, obs ObserveFunc) (cancel func(), err error)
+	File(path string, obs Observer, opts ...WatchOption) (w *Watch, err error)
 
 	// Files watches a list of files, calling the observer with any events.
-	// Only MODIFIED, OTHER, and DELETED events will be observed.
+	// Only MODIFIED, OTHER, and DELETED events will be observed,
+	// along with OVERFLOW if the system dropped events.
 	// See the File method for details about these event types.
-	Files(paths []string, obs ObserveFunc) (cancel func(), err error)
+	Files(paths []string, obs Observer, opts ...WatchOption) (w *Watch, err error)
 
 	// Recursively watches all files/folders under the given path, calling the observer with any events.
 	// A recurive watch is the only way to receive CREATED events for new files and folders.
+	// Renames within the watched tree are observed as a single RENAMED event; a rename
+	// into or out of the tree is observed as CREATED or DELETED respectively.
 	//
 	// Note: a recursive watch is not always supported by the host operating system, in which case
 	// ErrRecursiveUnsupported is returned. In this situation, this code will function similarly:
 	//
 	//   fileset, _ := fswatch.EnumerateFiles(path, true)
-	//   cancel, _ := fswatch.Files(fileset, obs)
+	//   w, _ := fswatch.Files(fileset, obs)
 	//
 	// An important caveat of the code above: you will not receive CREATED notifications for new files.
-	Recursively(path string, obs ObserveFunc) (cancel func(), err error)
+	Recursively(path string, obs Observer, opts ...WatchOption) (w *Watch, err error)
+
+	// FileContext is like File, but the watch also ends when ctx is done,
+	// in which case its Err method returns ctx.Err().
+	FileContext(ctx context.Context, path string, obs Observer, opts ...WatchOption) (w *Watch, err error)
+
+	// FilesContext is like Files, but the watch also ends when ctx is done,
+	// in which case its Err method returns ctx.Err().
+	FilesContext(ctx context.Context, paths []string, obs Observer, opts ...WatchOption) (w *Watch, err error)
+
+	// RecursivelyContext is like Recursively, but the watch also ends when ctx is done,
+	// in which case its Err method returns ctx.Err().
+	RecursivelyContext(ctx context.Context, path string, obs Observer, opts ...WatchOption) (w *Watch, err error)
+
+	// NewWatcher returns a Watcher delivering events for paths on a channel
+	// with a buffer of bufsize events, instead of calling an observer.
+	// The paths are watched as if by Files, or each one as if by Recursively
+	// when recursive is true. Close the Watcher to stop watching.
+	NewWatcher(paths []string, recursive bool, bufsize int, opts ...WatchOption) (*Watcher, error)
 }
 
 // File watches a single file, calling the observer with any events.
@@ -69,13 +153,14 @@ type Interface interface {
 //     permissions, access time, link count, etc.
 //   - DELETED indicates that the watched file was removed.
 //     No further events will be generated for the file.
-func File(path string, obs ObserveFunc) (cancel func(), err error) {
-	return wrapFiles(impl, []string{path}, obs)
+func File(path string, obs Observer, opts ...WatchOption) (w *Watch, err error) {
+	return wrapFiles(impl, []string{path}, obs, opts...)
 }
 
 // Files watches a list of files, calling the observer with any events.
-// Only MODIFIED, OTHER, and DELETED events will be observed.
+// Only MODIFIED, OTHER, and DELETED events will be observed,
+// along with OVERFLOW if the system dropped events.
 // See the File method for details about these event types.
-func Files(paths []string, obs ObserveFunc) (cancel func(), err error) {
-	return wrapFiles(impl, paths, obs)
+func Files(paths []string, obs Observer, opts ...WatchOption) (w *Watch, err error) {
+	return wrapFiles(impl, paths, obs, opts...)
 }
@@ -0,0 +1,296 @@
+// Package fswatchtest provides a fake fswatch.Interface for tests of code
+// using fswatch. Its events are emitted by the test instead of the filesystem,
+// and delivered before Emit returns, so tests need no real files or sleeping.
+package fswatchtest
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/fswatch/fswatch"
+)
+
+// Fake is an fswatch.Interface whose events are emitted by calling Emit.
+// Paths are never looked up on disk: a relative path is made absolute, and
+// an event is delivered to every running watch of its path, or of a folder
+// above it for recursive watches. Filters and other watch options apply as
+// they do for the real implementations, with directories known by the IsDir
+// flag of the events, and ignore files written with WriteFile.
+type Fake struct {
+	fswatch.Interface
+
+	mu      sync.Mutex
+	watches []*watch
+	files   map[string][]byte // by absolute path
+}
+
+// New returns a Fake configured by o. The backend of o is ignored.
+// It panics if the options are invalid.
+func New(o fswatch.Options) *Fake {
+	f := &Fake{}
+	x, err := fswatch.NewWithBackend((*backend)(f), o)
+	if err != nil {
+		panic(err)
+	}
+	f.Interface = x
+	return f
+}
+
+// WriteFile sets the contents of the file at path, such as an ignore file
+// read by watches with fswatch.GitIgnore. Like on disk, watches read it
+// once they start, and again after an event for it.
+func (f *Fake) WriteFile(path string, data []byte) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.files == nil {
+		f.files = make(map[string][]byte)
+	}
+	f.files[abs(path)] = append([]byte(nil), data...)
+}
+
+// Emit delivers an event of type t for path to the watches of path.
+func (f *Fake) Emit(path string, t fswatch.EventType) {
+	f.EmitEvent(fswatch.Event{Path: path, Type: t})
+}
+
+// EmitDir delivers an event of type t for the directory at path.
+func (f *Fake) EmitDir(path string, t fswatch.EventType) {
+	f.EmitEvent(fswatch.Event{Path: path, Type: t, IsDir: true})
+}
+
+// Rename delivers a RENAMED event from oldpath to path.
+func (f *Fake) Rename(oldpath, path string) {
+	f.EmitEvent(fswatch.Event{Path: path, OldPath: oldpath, Type: fswatch.RENAMED})
+}
+
+// EmitEvent delivers ev to the watches of its path, or of its old path.
+// A watch whose observer returns an error ends with that error.
+func (f *Fake) EmitEvent(ev fswatch.Event) {
+	f.EmitBatch(ev)
+}
+
+// EmitBatch delivers events together, as the system delivers the events of
+// a short time, in a single batch to each watch of any of their paths.
+func (f *Fake) EmitBatch(evts ...fswatch.Event) {
+	evts = append([]fswatch.Event(nil), evts...)
+	for i, ev := range evts {
+		evts[i].Path = abs(ev.Path)
+		if ev.OldPath != "" {
+			evts[i].OldPath = abs(ev.OldPath)
+		}
+	}
+	for _, w := range f.running() {
+		var batch []fswatch.Event
+		for _, ev := range evts {
+			if w.covers(ev.Path) || (ev.OldPath != "" && w.covers(ev.OldPath)) {
+				batch = append(batch, ev)
+			}
+		}
+		if len(batch) == 0 {
+			continue
+		}
+		if err := w.obs(batch); err != nil {
+			w.bw.Close(err)
+		}
+	}
+}
+
+// Fail ends every running watch with err, as if the system failed.
+func (f *Fake) Fail(err error) {
+	for _, w := range f.running() {
+		w.bw.Close(err)
+	}
+}
+
+// Watches returns how many watches are running.
+func (f *Fake) Watches() int {
+	return len(f.running())
+}
+
+// running returns the watches which have not ended.
+func (f *Fake) running() []*watch {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	res := f.watches[:0]
+	for _, w := range f.watches {
+		select {
+		case <-w.bw.Done():
+		default:
+			res = append(res, w)
+		}
+	}
+	f.watches = res
+	return append([]*watch(nil), res...)
+}
+
+// backend is the fswatch.Backend of a Fake.
+type backend Fake
+
+func (x *backend) ResolvePath(path string) (string, error) {
+	return abs(path), nil
+}
+
+// ReadFile implements fswatch.FileReader, so ignore files are read from
+// the files written with WriteFile.
+func (x *backend) ReadFile(name string) ([]byte, error) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	data, ok := x.files[name]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return data, nil
+}
+
+func (x *backend) Files(paths []string, obs fswatch.BatchObserver, opts fswatch.BackendOptions) (*fswatch.BackendWatch, error) {
+	return x.start(paths, false, obs), nil
+}
+
+func (x *backend) Recursively(path string, obs fswatch.BatchObserver, opts fswatch.BackendOptions) (*fswatch.BackendWatch, error) {
+	return x.start([]string{path}, true, obs), nil
+}
+
+func (x *backend) start(paths []string, recursive bool, obs fswatch.BatchObserver) *fswatch.BackendWatch {
+	w := &watch{
+		recursive: recursive,
+		paths:     append([]string(nil), paths...),
+		obs:       obs,
+		bw:        fswatch.NewBackendWatch(nil),
+	}
+	w.bw.SetPaths(w)
+
+	x.mu.Lock()
+	x.watches = append(x.watches, w)
+	x.mu.Unlock()
+	return w.bw
+}
+
+// watch is a running watch of a Fake.
+type watch struct {
+	recursive bool
+	obs       fswatch.BatchObserver
+	bw        *fswatch.BackendWatch
+
+	mu    sync.Mutex
+	paths []string
+}
+
+// covers reports whether the watch sees events for p.
+func (w *watch) covers(p string) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, wp := range w.paths {
+		if p == wp || (w.recursive && strings.HasPrefix(p, strings.TrimSuffix(wp, "/")+"/")) {
+			return true
+		}
+	}
+	return false
+}
+
+func (w *watch) Add(paths []string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.paths = append(w.paths, paths...)
+	return nil
+}
+
+func (w *watch) Remove(paths []string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, p := range paths {
+		for i, wp := range w.paths {
+			if wp == p {
+				w.paths = append(w.paths[:i], w.paths[i+1:]...)
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// abs makes p absolute without looking at the filesystem,
+// relative to the root for a relative path.
+func abs(p string) string {
+	if !filepath.IsAbs(p) {
+		p = string(filepath.Separator) + p
+	}
+	return filepath.Clean(p)
+}
+
+// TB is the part of testing.TB used to report failed expectations.
+type TB interface {
+	Helper()
+	Errorf(format string, args ...interface{})
+}
+
+// Recorder is an Observer which records the events it observes,
+// for checking them with Expect.
+type Recorder struct {
+	// Err is returned by Observe, which ends the watch if not nil.
+	Err error
+
+	mu     sync.Mutex
+	events []fswatch.Event
+}
+
+// Observe records ev.
+func (r *Recorder) Observe(ev fswatch.Event) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, ev)
+	return r.Err
+}
+
+// Events returns the events recorded since the last call to Expect or Reset.
+func (r *Recorder) Events() []fswatch.Event {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]fswatch.Event(nil), r.events...)
+}
+
+// Reset forgets the recorded events.
+func (r *Recorder) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = nil
+}
+
+// Expect reports an error to t unless exactly the events in want were
+// recorded, in order, and then forgets them. Call it without events to
+// expect that nothing was observed.
+func (r *Recorder) Expect(t TB, want ...fswatch.Event) {
+	t.Helper()
+	got := r.Events()
+	r.Reset()
+
+	ok := len(got) == len(want)
+	for i := 0; ok && i < len(got); i++ {
+		ok = got[i] == want[i]
+	}
+	if !ok {
+		t.Errorf("observed events:\n%s\nwant:\n%s", format(got), format(want))
+	}
+}
+
+// Event returns an event of type t for path, for use with Expect.
+func Event(path string, t fswatch.EventType) fswatch.Event {
+	return fswatch.Event{Path: path, Type: t}
+}
+
+func format(evts []fswatch.Event) string {
+	if len(evts) == 0 {
+		return "\t(none)"
+	}
+	lines := make([]string, len(evts))
+	for i, e := range evts {
+		lines[i] = fmt.Sprintf("\t%s %s", e.Type, e.Path)
+		if e.OldPath != "" {
+			lines[i] += " (from " + e.OldPath + ")"
+		}
+	}
+	return strings.Join(lines, "\n")
+}
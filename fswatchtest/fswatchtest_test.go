@@ -0,0 +1,51 @@
+package fswatchtest
+
+import (
+	"testing"
+
+	"github.com/fswatch/fswatch"
+)
+
+func TestGitIgnore(t *testing.T) {
+	f := New(fswatch.Options{})
+	f.WriteFile("/nowhere/.gitignore", []byte("*.log\nbuild/\n"))
+	var r Recorder
+	w, err := f.Recursively("/nowhere", &r, fswatch.GitIgnore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Cancel()
+
+	f.Emit("/nowhere/a.log", fswatch.CREATED)
+	f.Emit("/nowhere/build", fswatch.CREATED)
+	f.EmitDir("/nowhere/build", fswatch.CREATED)
+	f.Emit("/nowhere/a.go", fswatch.CREATED)
+	r.Expect(t,
+		Event("/nowhere/build", fswatch.CREATED),
+		Event("/nowhere/a.go", fswatch.CREATED),
+	)
+
+	// the rules are read again after the ignore file changed
+	f.WriteFile("/nowhere/.gitignore", []byte("*.go\n"))
+	f.Emit("/nowhere/.gitignore", fswatch.MODIFIED)
+	f.Emit("/nowhere/a.log", fswatch.CREATED)
+	f.Emit("/nowhere/a.go", fswatch.CREATED)
+	r.Expect(t,
+		Event("/nowhere/.gitignore", fswatch.MODIFIED),
+		Event("/nowhere/a.log", fswatch.CREATED),
+	)
+}
+
+func TestIncludeDir(t *testing.T) {
+	f := New(fswatch.Options{})
+	var r Recorder
+	w, err := f.Recursively("/nowhere", &r, fswatch.Include("src/"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Cancel()
+
+	f.Emit("/nowhere/src", fswatch.CREATED)
+	f.EmitDir("/nowhere/src", fswatch.CREATED)
+	r.Expect(t, fswatch.Event{Path: "/nowhere/src", Type: fswatch.CREATED, IsDir: true})
+}
@@ -6,6 +6,10 @@ import (
 
 var impl = fsevents.New(nil)
 
-func newImpl(opts map[string]interface{}) watcher {
-	return fsevents.New(nil)
+const defaultBackend = "fsevents"
+
+func init() {
+	register("fsevents", func(o Options) (watcher, error) {
+		return &fsevents.Interface{Latency: o.latency()}, nil
+	})
 }
@@ -1,11 +1,49 @@
 package fswatch
 
 import (
+	"errors"
+
+	"github.com/fswatch/fswatch/internal"
+	"github.com/fswatch/fswatch/internal/fanotify"
 	"github.com/fswatch/fswatch/internal/inotify"
 )
 
 var impl = inotify.New(nil)
 
-func newImpl(opts map[string]interface{}) watcher {
-	return inotify.New(opts)
+const defaultBackend = "inotify"
+
+func init() {
+	register("inotify", func(o Options) (watcher, error) {
+		return newInotify(o), nil
+	})
+	register("fanotify", func(o Options) (watcher, error) {
+		fan := &fanotify.Interface{
+			Latency:    o.latency(),
+			BufferSize: o.BufferSize,
+		}
+		return &withFanotify{Interface: newInotify(o), fan: fan}, nil
+	})
+}
+
+func newInotify(o Options) *inotify.Interface {
+	return &inotify.Interface{
+		Latency:    o.latency(),
+		BufferSize: o.BufferSize,
+		Rescan:     o.Rescan,
+	}
+}
+
+// withFanotify watches recursively with fanotify where privileges allow,
+// and with inotify otherwise.
+type withFanotify struct {
+	*inotify.Interface
+	fan *fanotify.Interface
+}
+
+func (x *withFanotify) Recursively(path string, obs internal.ObserveFunc, opts *internal.WatchOptions) (*internal.Watch, error) {
+	w, err := x.fan.Recursively(path, obs, opts)
+	if errors.Is(err, fanotify.ErrUnavailable) {
+		return x.Interface.Recursively(path, obs, opts)
+	}
+	return w, err
 }
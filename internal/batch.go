@@ -0,0 +1,62 @@
+package internal
+
+import "time"
+
+// Batcher collects the events of a watch for its latency, and delivers them
+// to the observer as a single batch, with repeated events coalesced. If the
+// observer returns an error, the watch is closed with that error and the
+// observer is not called again. It is meant to be used from the single
+// goroutine reading the events of a watch.
+type Batcher struct {
+	w       *Watch
+	obs     ObserveFunc
+	latency time.Duration
+
+	batch  []Event
+	due    <-chan time.Time
+	failed bool
+}
+
+// NewBatcher returns a Batcher delivering the events of w to obs. With a
+// latency of zero or less, events are delivered as soon as they are added.
+func NewBatcher(w *Watch, obs ObserveFunc, latency time.Duration) *Batcher {
+	return &Batcher{w: w, obs: obs, latency: latency}
+}
+
+// Due returns a channel which receives once the queued events should be
+// sent, or nil if none are queued.
+func (b *Batcher) Due() <-chan time.Time {
+	return b.due
+}
+
+// Add queues events for delivery at the end of the latency window.
+func (b *Batcher) Add(evts []Event) {
+	if b.failed || len(evts) == 0 {
+		return
+	}
+	b.batch = append(b.batch, evts...)
+	if b.latency <= 0 {
+		b.Send()
+	} else if b.due == nil {
+		b.due = time.After(b.latency)
+	}
+}
+
+// Send delivers the queued events, unless the watch was closed.
+func (b *Batcher) Send() {
+	evts := Coalesce(b.batch)
+	b.batch, b.due = nil, nil
+	if b.failed || len(evts) == 0 {
+		return
+	}
+	select {
+	case <-b.w.Done():
+		// cancelled, drop anything still pending
+		return
+	default:
+	}
+	if err := b.obs(evts); err != nil {
+		b.failed = true
+		b.w.Close(err)
+	}
+}
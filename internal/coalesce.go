@@ -0,0 +1,21 @@
+package internal
+
+// Coalesce removes repeated events from a batch, keeping the order of the
+// rest. An event is repeated when the previous event for the same path is
+// identical, so a series of writes to a file becomes a single MODIFIED event,
+// while a file that is created, deleted and created again keeps all three.
+func Coalesce(evts []Event) []Event {
+	if len(evts) < 2 {
+		return evts
+	}
+	last := make(map[string]Event, len(evts))
+	res := make([]Event, 0, len(evts))
+	for _, e := range evts {
+		if prev, ok := last[e.Path]; ok && prev == e {
+			continue
+		}
+		last[e.Path] = e
+		res = append(res, e)
+	}
+	return res
+}
@@ -0,0 +1,509 @@
+//go:build linux
+// +build linux
+
+// Package fanotify watches whole trees with a single fanotify mark for each
+// filesystem, instead of an inotify watch for each directory.
+package fanotify
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fswatch/fswatch/internal"
+	"golang.org/x/sys/unix"
+)
+
+// New returns a new fanotify-based filesystem watcher.
+// It supports 1 option:
+//
+//	"latency" = time.Duration, how long to collect events before delivering them
+func New(opts map[string]interface{}) *Interface {
+	lat := time.Second / 4
+	if opts != nil {
+		if x, ok := opts["latency"]; ok {
+			lat = x.(time.Duration)
+		}
+	}
+	return &Interface{
+		Latency: lat,
+	}
+}
+
+// ErrUnavailable is returned when fanotify can't watch a path, because the
+// process lacks the CAP_SYS_ADMIN and CAP_DAC_READ_SEARCH capabilities, or
+// the kernel or filesystem lacks support. Linux 5.9 is needed at least.
+var ErrUnavailable = errors.New("fanotify: not available")
+
+// Interface is a fanotify-based filesystem watcher. Every recursive watch
+// marks the whole filesystem of its paths, and only reports the events below
+// them. It is safe to start any number of watches concurrently; each one gets
+// its own fanotify instance.
+type Interface struct {
+	// Latency is how long events are collected before they are delivered
+	// to the observer as a single batch. Zero delivers events immediately.
+	Latency time.Duration
+
+	// BufferSize is how many reads from the kernel are queued while
+	// the observer is busy. Zero uses the default of 64.
+	BufferSize int
+}
+
+// defaultBufferSize is how many reads are queued by default.
+const defaultBufferSize = 64
+
+// maxDirs is how many directory names are cached before the cache is reset.
+const maxDirs = 1 << 16
+
+// watch is the state of a single fanotify instance.
+type watch struct {
+	fd      int
+	file    *os.File
+	handle  *internal.Watch
+	latency time.Duration
+	bufsize int
+
+	// mu guards everything below, which is shared
+	// between the event loop and Add or Remove
+	mu     sync.Mutex
+	closed bool // once the descriptor is closed
+	mask   uint64
+	opts   *internal.WatchOptions
+	roots  []string
+	mounts map[unix.Fsid]int // filesystem => descriptor to open file handles with
+	dirs   map[string]string // file handle => directory, a cache
+}
+
+// newWatch creates a new fanotify instance. The descriptor is non-blocking so
+// that closing the file also wakes up any pending read.
+func (x *Interface) newWatch(opts *internal.WatchOptions) (*watch, error) {
+	fd, err := unix.FanotifyInit(unix.FAN_CLASS_NOTIF|unix.FAN_CLOEXEC|unix.FAN_NONBLOCK|unix.FAN_REPORT_DFID_NAME,
+		unix.O_RDONLY|unix.O_CLOEXEC)
+	if err != nil {
+		return nil, fmt.Errorf("%w, %v", ErrUnavailable, err)
+	}
+	file := os.NewFile(uintptr(fd), "")
+	w := &watch{
+		fd:      fd,
+		file:    file,
+		latency: x.Latency,
+		bufsize: x.BufferSize,
+		mask:    mask(opts),
+		opts:    opts,
+		mounts:  make(map[unix.Fsid]int),
+		dirs:    make(map[string]string),
+	}
+	if w.bufsize <= 0 {
+		w.bufsize = defaultBufferSize
+	}
+	w.handle = internal.NewWatch(func() {
+		// the numbers of the descriptors may be reused once they are closed
+		w.mu.Lock()
+		w.closed = true
+		file.Close()
+		for _, mfd := range w.mounts {
+			unix.Close(mfd)
+		}
+		w.mounts = nil
+		w.mu.Unlock()
+	})
+	w.handle.Paths = w
+	return w, nil
+}
+
+// mask returns the events to mark, leaving out modifications and metadata
+// changes if they are not wanted. Renames are reported as a single event,
+// which needs Linux 5.17.
+func mask(opts *internal.WatchOptions) uint64 {
+	m := uint64(unix.FAN_CREATE | unix.FAN_DELETE | unix.FAN_RENAME | unix.FAN_ONDIR)
+	if opts.Wants(internal.MODIFIED) {
+		m |= unix.FAN_MODIFY
+	}
+	if opts.Wants(internal.OTHER) {
+		m |= unix.FAN_ATTRIB
+	}
+	return m
+}
+
+// mark adds a mark for the filesystem of path, unless it has one already.
+// It must be called with x.mu held.
+func (x *watch) mark(path string) error {
+	var st unix.Statfs_t
+	if err := unix.Statfs(path, &st); err != nil {
+		return err
+	}
+	if _, ok := x.mounts[st.Fsid]; ok {
+		return nil
+	}
+
+	err := unix.FanotifyMark(x.fd, unix.FAN_MARK_ADD|unix.FAN_MARK_FILESYSTEM, x.mask, unix.AT_FDCWD, path)
+	if err == unix.EINVAL && x.mask&unix.FAN_RENAME != 0 {
+		// older kernels report a rename as separate events
+		x.mask = x.mask&^unix.FAN_RENAME | unix.FAN_MOVE
+		err = unix.FanotifyMark(x.fd, unix.FAN_MARK_ADD|unix.FAN_MARK_FILESYSTEM, x.mask, unix.AT_FDCWD, path)
+	}
+	if err != nil {
+		return fmt.Errorf("%w, %v", ErrUnavailable, err)
+	}
+
+	mfd, err := unix.Open(path, unix.O_RDONLY|unix.O_DIRECTORY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return err
+	}
+	// make sure events can be mapped back to paths
+	fh, _, err := unix.NameToHandleAt(unix.AT_FDCWD, path, 0)
+	if err == nil {
+		var fd int
+		if fd, err = unix.OpenByHandleAt(mfd, fh, unix.O_PATH); err == nil {
+			unix.Close(fd)
+		}
+	}
+	if err != nil {
+		unix.Close(mfd)
+		return fmt.Errorf("%w, %v", ErrUnavailable, err)
+	}
+	x.mounts[st.Fsid] = mfd
+	return nil
+}
+
+// record is a decoded info record, naming an entry of a directory.
+type record struct {
+	typ    uint8
+	fsid   unix.Fsid
+	handle unix.FileHandle
+	name   string
+}
+
+// rawEvent is a single decoded fanotify event.
+type rawEvent struct {
+	mask    uint64
+	records []record
+}
+
+// readEvents decodes the events in b, as read from a fanotify instance.
+func readEvents(b []byte) []rawEvent {
+	var evts []rawEvent
+	for len(b) >= unix.FAN_EVENT_METADATA_LEN {
+		n := int(binary.LittleEndian.Uint32(b[0:]))
+		mlen := int(binary.LittleEndian.Uint16(b[6:]))
+		if n < unix.FAN_EVENT_METADATA_LEN || n > len(b) || mlen > n {
+			break
+		}
+		re := rawEvent{mask: binary.LittleEndian.Uint64(b[8:])}
+
+		info := b[mlen:n]
+		for len(info) >= 4 {
+			size := int(binary.LittleEndian.Uint16(info[2:]))
+			if size < 4 || size > len(info) {
+				break
+			}
+			if rec, ok := readRecord(info[0], info[4:size]); ok {
+				re.records = append(re.records, rec)
+			}
+			info = info[size:]
+		}
+
+		evts = append(evts, re)
+		b = b[n:]
+	}
+	return evts
+}
+
+// readRecord decodes an info record with a directory file handle and a name.
+func readRecord(typ uint8, b []byte) (record, bool) {
+	switch typ {
+	case unix.FAN_EVENT_INFO_TYPE_DFID_NAME, unix.FAN_EVENT_INFO_TYPE_DFID,
+		unix.FAN_EVENT_INFO_TYPE_OLD_DFID_NAME, unix.FAN_EVENT_INFO_TYPE_NEW_DFID_NAME:
+	default:
+		return record{}, false
+	}
+	if len(b) < 16 {
+		return record{}, false
+	}
+	rec := record{typ: typ}
+	rec.fsid.Val[0] = int32(binary.LittleEndian.Uint32(b[0:]))
+	rec.fsid.Val[1] = int32(binary.LittleEndian.Uint32(b[4:]))
+	hlen := int(binary.LittleEndian.Uint32(b[8:]))
+	htype := int32(binary.LittleEndian.Uint32(b[12:]))
+	if 16+hlen > len(b) {
+		return record{}, false
+	}
+	rec.handle = unix.NewFileHandle(htype, b[16:16+hlen])
+
+	name := b[16+hlen:]
+	if i := bytes.IndexByte(name, 0); i >= 0 {
+		name = name[:i]
+	}
+	rec.name = string(name)
+	return rec, true
+}
+
+// run reads events until the watch is closed, delivering them in batches.
+func (x *watch) run(obs internal.ObserveFunc) {
+	raw := make(chan []rawEvent, x.bufsize)
+	go func() {
+		defer close(raw)
+		buf := make([]byte, 64<<10)
+		for {
+			n, err := x.file.Read(buf)
+			if err != nil {
+				if errors.Is(err, os.ErrClosed) {
+					err = nil
+				} else {
+					err = fmt.Errorf("fanotify: read error, %w", err)
+				}
+				x.handle.Close(err)
+				return
+			}
+			raw <- readEvents(buf[:n])
+		}
+	}()
+
+	b := internal.NewBatcher(x.handle, obs, x.latency)
+	for {
+		var evts []internal.Event
+
+		select {
+		case <-b.Due():
+			b.Send()
+			continue
+
+		case res, ok := <-raw:
+			if !ok {
+				return
+			}
+			x.mu.Lock()
+			for _, re := range res {
+				evts = append(evts, x.process(re)...)
+			}
+			x.mu.Unlock()
+		}
+
+		b.Add(evts)
+	}
+}
+
+// process handles a single raw event, returning the events to report.
+// It must be called with x.mu held.
+func (x *watch) process(re rawEvent) []internal.Event {
+	if re.mask&unix.FAN_Q_OVERFLOW != 0 {
+		var evts []internal.Event
+		for _, root := range x.roots {
+			evts = append(evts, internal.Event{Path: root, Type: internal.OVERFLOW})
+		}
+		return evts
+	}
+
+	if re.mask&unix.FAN_RENAME != 0 {
+		var oldpath, newpath string
+		var oldok, newok bool
+		for _, rec := range re.records {
+			switch rec.typ {
+			case unix.FAN_EVENT_INFO_TYPE_OLD_DFID_NAME:
+				oldpath, oldok = x.path(rec)
+			case unix.FAN_EVENT_INFO_TYPE_NEW_DFID_NAME:
+				newpath, newok = x.path(rec)
+			}
+		}
+		if re.mask&unix.FAN_ONDIR != 0 && oldpath != "" {
+			x.moveDir(oldpath, newpath, newpath != "")
+		}
+		switch {
+		case oldok && newok:
+			return []internal.Event{{Path: newpath, OldPath: oldpath, Type: internal.RENAMED}}
+		case oldok:
+			return []internal.Event{{Path: oldpath, Type: internal.DELETED}}
+		case newok:
+			return []internal.Event{{Path: newpath, Type: internal.CREATED}}
+		}
+		return nil
+	}
+
+	if len(re.records) == 0 {
+		return nil
+	}
+	p, ok := x.path(re.records[0])
+	if !ok {
+		return nil
+	}
+	if re.mask&unix.FAN_ONDIR != 0 && re.mask&(unix.FAN_DELETE|unix.FAN_MOVED_FROM) != 0 {
+		x.moveDir(p, "", false)
+	}
+
+	// several changes to the same entry may be merged into one event
+	var evts []internal.Event
+	if re.mask&(unix.FAN_CREATE|unix.FAN_MOVED_TO) != 0 {
+		evts = append(evts, internal.Event{Path: p, Type: internal.CREATED})
+	}
+	if re.mask&unix.FAN_MODIFY != 0 {
+		evts = append(evts, internal.Event{Path: p, Type: internal.MODIFIED})
+	}
+	if re.mask&unix.FAN_ATTRIB != 0 {
+		evts = append(evts, internal.Event{Path: p, Type: internal.OTHER})
+	}
+	if re.mask&(unix.FAN_DELETE|unix.FAN_MOVED_FROM) != 0 {
+		evts = append(evts, internal.Event{Path: p, Type: internal.DELETED})
+	}
+	return evts
+}
+
+// path returns the path of the entry named by an info record, and
+// whether it is inside the watch. It must be called with x.mu held.
+func (x *watch) path(rec record) (string, bool) {
+	key := fmt.Sprintf("%x:%d:%x", rec.fsid.Val, rec.handle.Type(), rec.handle.Bytes())
+	dir, ok := x.dirs[key]
+	if !ok {
+		mfd, ok := x.mounts[rec.fsid]
+		if !ok {
+			return "", false
+		}
+		fd, err := unix.OpenByHandleAt(mfd, rec.handle, unix.O_PATH)
+		if err != nil {
+			// the directory is gone
+			return "", false
+		}
+		dir, err = os.Readlink("/proc/self/fd/" + strconv.Itoa(fd))
+		unix.Close(fd)
+		if err != nil || strings.HasSuffix(dir, " (deleted)") {
+			return "", false
+		}
+		if len(x.dirs) >= maxDirs {
+			x.forget()
+		}
+		x.dirs[key] = dir
+	}
+
+	p := dir
+	if rec.name != "" && rec.name != "." {
+		p = filepath.Join(dir, rec.name)
+	}
+	return p, x.inside(p)
+}
+
+// inside reports whether p is one of the roots or below one, and not below
+// a directory skipped by the options. It must be called with x.mu held.
+func (x *watch) inside(p string) bool {
+	for _, root := range x.roots {
+		if p == root {
+			return true
+		}
+		if !strings.HasPrefix(p, strings.TrimSuffix(root, "/")+"/") {
+			continue
+		}
+		for dir := filepath.Dir(p); len(dir) > len(root); dir = filepath.Dir(dir) {
+			if x.opts.SkipDir(dir) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// moveDir updates the cached names of oldpath and the directories below it
+// after it was renamed to newpath, or forgets them if it moved out of the
+// watch or was deleted. It must be called with x.mu held.
+func (x *watch) moveDir(oldpath, newpath string, moved bool) {
+	for key, dir := range x.dirs {
+		if dir != oldpath && !strings.HasPrefix(dir, oldpath+"/") {
+			continue
+		}
+		if moved {
+			x.dirs[key] = newpath + strings.TrimPrefix(dir, oldpath)
+		} else {
+			delete(x.dirs, key)
+		}
+	}
+}
+
+// forget empties the cache of directory names.
+// It must be called with x.mu held.
+func (x *watch) forget() {
+	x.dirs = make(map[string]string)
+}
+
+// Add adds paths to a running watch, each of which is watched recursively.
+// If any path can't be added, none of them are: the filesystems marked stay
+// marked until the watch ends, but their events are not reported.
+func (x *watch) Add(paths []string) error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if x.closed {
+		return internal.ErrEnded
+	}
+
+	nroots := len(x.roots)
+	for _, p := range paths {
+		if err := x.mark(p); err != nil {
+			x.roots = x.roots[:nroots]
+			return err
+		}
+		if !x.covers(p) {
+			x.roots = append(x.roots, p)
+		}
+	}
+	return nil
+}
+
+// covers reports whether p is already a root of the watch, or below one.
+// It must be called with x.mu held.
+func (x *watch) covers(p string) bool {
+	for _, root := range x.roots {
+		if root == p || strings.HasPrefix(p, strings.TrimSuffix(root, "/")+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// Remove removes paths from a running watch. The filesystems stay marked
+// until the watch ends, but their events are no longer reported.
+func (x *watch) Remove(paths []string) error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if x.closed {
+		return internal.ErrEnded
+	}
+
+	for _, p := range paths {
+		p = filepath.Clean(p)
+		for i, root := range x.roots {
+			if root == p {
+				x.roots = append(x.roots[:i], x.roots[i+1:]...)
+				break
+			}
+		}
+	}
+	x.forget()
+	return nil
+}
+
+// Files is not supported, as watching single files is what inotify does best.
+func (x *Interface) Files(paths []string, obs internal.ObserveFunc, opts *internal.WatchOptions) (*internal.Watch, error) {
+	return nil, internal.ErrNotImplemented
+}
+
+// Recursively watches all files/folders under the given path, calling the observer with any events.
+// Directories skipped by the options are still marked, but their events are not reported.
+// ErrUnavailable is returned if fanotify can't watch the path.
+func (x *Interface) Recursively(path string, obs internal.ObserveFunc, opts *internal.WatchOptions) (*internal.Watch, error) {
+	w, err := x.newWatch(opts)
+	if err != nil {
+		return nil, err
+	}
+	if err = w.Add([]string{path}); err != nil {
+		w.handle.Cancel()
+		return nil, err
+	}
+
+	go w.run(obs)
+
+	return w.handle, nil
+}
@@ -0,0 +1,161 @@
+// Package filter matches paths against include and exclude glob patterns,
+// and against the rules of gitignore-style files.
+//
+// Patterns use '/' as the separator and are matched against paths relative
+// to the root of a watch. Each element of a pattern follows the syntax of
+// path.Match, and an element of "**" matches any number of path elements, or
+// at the end of a pattern one or more, so "a/**" matches everything below a,
+// but not a itself. A pattern without a '/' is matched against the last element of the path
+// only, and a pattern ending in '/' only matches directories.
+package filter
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// Filter decides which paths of a watch are of interest.
+type Filter struct {
+	include []pattern
+	exclude []pattern
+}
+
+type pattern struct {
+	elems   []string
+	base    bool // match the base name only
+	dirOnly bool // only match directories
+}
+
+// New returns a Filter for the given patterns. A path is of interest if
+// it matches any of the include patterns (or there are none), and neither
+// it nor any directory above it matches an exclude pattern.
+func New(include, exclude []string) (*Filter, error) {
+	f := &Filter{}
+	for _, p := range include {
+		pat, err := parse(p)
+		if err != nil {
+			return nil, err
+		}
+		f.include = append(f.include, pat)
+	}
+	for _, p := range exclude {
+		pat, err := parse(p)
+		if err != nil {
+			return nil, err
+		}
+		f.exclude = append(f.exclude, pat)
+	}
+	return f, nil
+}
+
+func parse(p string) (pattern, error) {
+	pat := pattern{}
+	if strings.HasSuffix(p, "/") {
+		pat.dirOnly = true
+		p = strings.TrimRight(p, "/")
+	}
+	pat.base = !strings.Contains(p, "/")
+	p = strings.TrimPrefix(p, "/")
+	if p == "" {
+		return pat, fmt.Errorf("filter: empty pattern")
+	}
+	pat.elems = strings.Split(p, "/")
+	for _, e := range pat.elems {
+		// check the syntax, the error is the same for any name
+		if _, err := path.Match(e, ""); err != nil {
+			return pat, fmt.Errorf("filter: bad pattern %q, %w", p, err)
+		}
+	}
+	return pat, nil
+}
+
+// match reports whether the slash-separated path rel matches the pattern.
+func (x pattern) match(rel string, isDir bool) bool {
+	if x.dirOnly && !isDir {
+		return false
+	}
+	if x.base {
+		ok, _ := path.Match(x.elems[0], path.Base(rel))
+		return ok
+	}
+	return matchElems(x.elems, strings.Split(rel, "/"))
+}
+
+func matchElems(pat, name []string) bool {
+	for len(pat) > 0 {
+		if pat[0] == "**" {
+			if len(pat) == 1 {
+				// everything inside, as in gitignore
+				return len(name) > 0
+			}
+			for i := 0; i <= len(name); i++ {
+				if matchElems(pat[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pat[0], name[0]); !ok {
+			return false
+		}
+		pat, name = pat[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+// NeedsDir reports whether Match depends on whether a path is a directory.
+func (f *Filter) NeedsDir() bool {
+	for _, pat := range f.include {
+		if pat.dirOnly {
+			return true
+		}
+	}
+	for _, pat := range f.exclude {
+		if pat.dirOnly {
+			return true
+		}
+	}
+	return false
+}
+
+// Skip reports whether the directory rel is excluded, along with everything below it.
+func (f *Filter) Skip(rel string) bool {
+	return f.excluded(rel, true)
+}
+
+// Match reports whether the path rel is of interest.
+func (f *Filter) Match(rel string, isDir bool) bool {
+	if rel == "" || rel == "." {
+		return true
+	}
+	if f.excluded(rel, isDir) {
+		return false
+	}
+	for dir := path.Dir(rel); dir != "." && dir != "/"; dir = path.Dir(dir) {
+		if f.excluded(dir, true) {
+			return false
+		}
+	}
+	if len(f.include) == 0 {
+		return true
+	}
+	for _, pat := range f.include {
+		if pat.match(rel, isDir) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *Filter) excluded(rel string, isDir bool) bool {
+	for _, pat := range f.exclude {
+		if pat.match(rel, isDir) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,152 @@
+package filter
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Ignore matches paths against the rules of gitignore-style files found in
+// the directories of a watch. A rule in a deeper directory takes precedence
+// over one above it, and a later rule over an earlier one, so a negated
+// pattern can re-include a path. Nothing below an ignored directory can be
+// re-included.
+type Ignore struct {
+	// ReadFile reads an ignore file, from the local filesystem if nil.
+	ReadFile func(name string) ([]byte, error)
+
+	names []string
+
+	mu   sync.RWMutex
+	dirs map[string][]rule // directory => rules of its ignore files
+}
+
+type rule struct {
+	pattern
+	negate bool
+}
+
+// NewIgnore returns an Ignore reading the files with the given names,
+// in order, from each directory.
+func NewIgnore(names []string) *Ignore {
+	return &Ignore{
+		names: names,
+		dirs:  make(map[string][]rule),
+	}
+}
+
+// IsIgnoreFile reports whether p is the path of an ignore file.
+func (x *Ignore) IsIgnoreFile(p string) bool {
+	base := filepath.Base(p)
+	for _, name := range x.names {
+		if base == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Enter loads the ignore files in dir, unless they were loaded before.
+func (x *Ignore) Enter(dir string) {
+	x.mu.RLock()
+	_, ok := x.dirs[dir]
+	x.mu.RUnlock()
+	if !ok {
+		x.Load(dir)
+	}
+}
+
+// Load reads the ignore files in dir, replacing any rules read before.
+// Missing or unreadable files have no rules.
+func (x *Ignore) Load(dir string) {
+	readFile := x.ReadFile
+	if readFile == nil {
+		readFile = os.ReadFile
+	}
+	var rules []rule
+	for _, name := range x.names {
+		data, err := readFile(filepath.Join(dir, name))
+		if err != nil {
+			continue
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			if r, ok := parseRule(line); ok {
+				rules = append(rules, r)
+			}
+		}
+	}
+
+	x.mu.Lock()
+	x.dirs[dir] = rules
+	x.mu.Unlock()
+}
+
+// parseRule parses a line of an ignore file, reporting false for
+// blank lines, comments, and invalid patterns.
+func parseRule(line string) (rule, bool) {
+	line = strings.TrimSuffix(line, "\r")
+	// trailing spaces are ignored unless escaped
+	for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, `\ `) {
+		line = line[:len(line)-1]
+	}
+	if line == "" || line[0] == '#' {
+		return rule{}, false
+	}
+
+	r := rule{}
+	if line[0] == '!' {
+		r.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, `\!`) || strings.HasPrefix(line, `\#`) {
+		line = line[1:]
+	}
+	// path.Match negates a character class with '^' instead of '!'
+	line = strings.ReplaceAll(line, "[!", "[^")
+
+	pat, err := parse(line)
+	if err != nil {
+		return r, false
+	}
+	r.pattern = pat
+	return r, true
+}
+
+// Ignored reports whether the path p below root is ignored, by its own
+// rules or because a directory above it is. Only the ignore files in root
+// and below apply, and p is never ignored if it is not below root.
+func (x *Ignore) Ignored(root, p string, isDir bool) bool {
+	rel, err := filepath.Rel(root, p)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return false
+	}
+	elems := strings.Split(filepath.ToSlash(rel), "/")
+
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	for i := 1; i <= len(elems); i++ {
+		if x.match(root, elems[:i], isDir || i < len(elems)) {
+			return true
+		}
+	}
+	return false
+}
+
+// match applies the rules of each directory above the path elems,
+// the deepest ones last. It must be called with x.mu held.
+func (x *Ignore) match(root string, elems []string, isDir bool) bool {
+	ignored := false
+	dir := root
+	for i := range elems {
+		if i > 0 {
+			dir = filepath.Join(dir, elems[i-1])
+		}
+		rel := strings.Join(elems[i:], "/")
+		for _, r := range x.dirs[dir] {
+			if r.match(rel, isDir) {
+				ignored = !r.negate
+			}
+		}
+	}
+	return ignored
+}
@@ -0,0 +1,156 @@
+package filter
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseRule(t *testing.T) {
+	tests := []struct {
+		line   string
+		ok     bool
+		negate bool
+		match  []string // paths the rule matches, directories with a trailing '/'
+		miss   []string // paths it does not match
+	}{
+		{line: "", ok: false},
+		{line: "   ", ok: false},
+		{line: "\r", ok: false},
+		{line: "# comment", ok: false},
+		{line: "[", ok: false},
+		{line: "!", ok: false},
+
+		{line: "*.log", ok: true, match: []string{"a.log", "x/y/b.log", "dir.log/"}, miss: []string{"a.txt", "log"}},
+		{line: "*.log\r", ok: true, match: []string{"a.log"}},
+		{line: "foo  ", ok: true, match: []string{"foo"}, miss: []string{"foo  "}},
+		{line: `foo\ `, ok: true, match: []string{"foo "}, miss: []string{"foo"}},
+		{line: `\#file`, ok: true, match: []string{"#file"}},
+		{line: `\!important`, ok: true, match: []string{"!important"}, miss: []string{"important"}},
+		{line: "!keep.log", ok: true, negate: true, match: []string{"keep.log", "x/keep.log"}},
+
+		// anchoring
+		{line: "/build", ok: true, match: []string{"build", "build/"}, miss: []string{"src/build"}},
+		{line: "doc/*.txt", ok: true, match: []string{"doc/a.txt"}, miss: []string{"x/doc/a.txt", "doc/x/a.txt"}},
+		{line: "**/foo", ok: true, match: []string{"foo", "a/b/foo"}, miss: []string{"foo/a"}},
+		{line: "a/**/b", ok: true, match: []string{"a/b", "a/x/y/b"}, miss: []string{"b", "x/a/b"}},
+		{line: "a/**", ok: true, match: []string{"a/x", "a/x/y"}, miss: []string{"a", "a/"}},
+
+		// directory-only
+		{line: "build/", ok: true, match: []string{"build/", "x/build/"}, miss: []string{"build", "x/build"}},
+		{line: "/out/", ok: true, match: []string{"out/"}, miss: []string{"out", "x/out/"}},
+
+		// character classes, negated with '!' as in git
+		{line: "[!a]x", ok: true, match: []string{"bx"}, miss: []string{"ax"}},
+		{line: "[ab]x", ok: true, match: []string{"ax", "bx"}, miss: []string{"cx"}},
+	}
+	for _, tt := range tests {
+		r, ok := parseRule(tt.line)
+		if ok != tt.ok {
+			t.Errorf("parseRule(%q) ok = %v, want %v", tt.line, ok, tt.ok)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if r.negate != tt.negate {
+			t.Errorf("parseRule(%q) negate = %v, want %v", tt.line, r.negate, tt.negate)
+		}
+		for _, p := range tt.match {
+			if !r.match(strings.TrimSuffix(p, "/"), strings.HasSuffix(p, "/")) {
+				t.Errorf("rule %q does not match %q", tt.line, p)
+			}
+		}
+		for _, p := range tt.miss {
+			if r.match(strings.TrimSuffix(p, "/"), strings.HasSuffix(p, "/")) {
+				t.Errorf("rule %q matches %q", tt.line, p)
+			}
+		}
+	}
+}
+
+func TestIgnored(t *testing.T) {
+	root := t.TempDir()
+	files := map[string]string{
+		".gitignore":      "# generated\n*.log\n!keep.log\n/build/\ntmp/\n!tmp/keep\n",
+		".fswatchignore":  "*.swp\n",
+		"sub/.gitignore":  "!*.log\nsecret\n",
+		"sub/deep/.empty": "",
+	}
+	for name, data := range files {
+		p := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	x := NewIgnore([]string{".gitignore", ".fswatchignore"})
+	x.Load(root)
+	x.Enter(filepath.Join(root, "sub"))
+	x.Enter(filepath.Join(root, "sub", "deep"))
+
+	tests := []struct {
+		path string // relative to root, directories with a trailing '/'
+		want bool
+	}{
+		{"a.log", true},
+		{"x/a.log", true},
+		{"keep.log", false},
+		{"a.txt", false},
+		{"a.swp", true},
+
+		// a deeper file takes precedence
+		{"sub/a.log", false},
+		{"sub/deep/a.log", false},
+		{"sub/secret", true},
+		{"secret", false},
+
+		// anchored and directory-only rules
+		{"build/", true},
+		{"build", false},
+		{"build/main.go", true},
+		{"src/build/", false},
+		{"tmp/", true},
+		{"a/tmp/", true},
+		{"a/tmp", false},
+
+		// nothing below an ignored directory can be re-included
+		{"tmp/keep", true},
+
+		// the root, and paths outside of it, are never ignored
+		{"", false},
+		{"../a.log", false},
+	}
+	for _, tt := range tests {
+		p := filepath.Join(root, filepath.FromSlash(strings.TrimSuffix(tt.path, "/")))
+		if got := x.Ignored(root, p, strings.HasSuffix(tt.path, "/")); got != tt.want {
+			t.Errorf("Ignored(%q) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+
+	// rules are read again on Load
+	os.WriteFile(filepath.Join(root, ".gitignore"), []byte("*.txt\n"), 0644)
+	x.Load(root)
+	if x.Ignored(root, filepath.Join(root, "a.log"), false) || !x.Ignored(root, filepath.Join(root, "a.txt"), false) {
+		t.Errorf("rules were not reloaded")
+	}
+}
+
+func TestIsIgnoreFile(t *testing.T) {
+	x := NewIgnore([]string{".gitignore", ".fswatchignore"})
+	for p, want := range map[string]bool{
+		"/a/.gitignore":     true,
+		"/a/.fswatchignore": true,
+		".gitignore":        true,
+		"/a/gitignore":      false,
+		"/.gitignore/x":     false,
+	} {
+		if got := x.IsIgnoreFile(p); got != want {
+			t.Errorf("IsIgnoreFile(%q) = %v, want %v", p, got, want)
+		}
+	}
+}
@@ -29,20 +29,25 @@ import (
 	"github.com/fswatch/fswatch/internal"
 )
 
+// Interface is an fsevents-based filesystem watcher. It is safe to start any
+// number of watches concurrently; each one gets its own event stream.
 type Interface struct {
 	Latency time.Duration
+}
 
-	mu       sync.Mutex
-	streamID int
+// stream is the state of a single FSEventStream.
+type stream struct {
+	id int
 
-	stream  C.FSEventStreamRef
+	ref     C.FSEventStreamRef
 	rlref   C.CFRunLoopRef
 	obsChan chan []internal.Event
 }
 
 var (
 	mu      sync.Mutex
-	actives = make(map[int]*Interface)
+	lastID  int
+	actives = make(map[int]*stream)
 )
 
 //export fsevtCallback
@@ -129,12 +134,12 @@ func fsevtCallback(stream C.FSEventStreamRef, info uintptr, numEvents C.size_t,
 	}
 
 	mu.Lock()
-	inter, ok := actives[int(info)]
+	st, ok := actives[int(info)]
 	mu.Unlock()
 	if !ok {
 		panic("fsevents received event before ready")
 	}
-	inter.obsChan <- events
+	st.obsChan <- events
 }
 
 // createPaths accepts the user defined set of paths and returns FSEvents
@@ -155,52 +160,61 @@ func makeCFString(str string) C.CFStringRef {
 	return C.CFStringCreateWithCString(C.kCFAllocatorDefault, s, C.kCFStringEncodingUTF8)
 }
 
-func (x *Interface) start(paths []string) {
+// start creates and schedules a new stream for the given paths.
+func (x *Interface) start(paths []string) *stream {
 	cPaths := createPaths(paths)
 	defer C.CFRelease(C.CFTypeRef(cPaths))
 
-	mu.Lock()
-	if x.streamID == 0 {
-		x.streamID = len(actives) + 1
+	st := &stream{
+		obsChan: make(chan []internal.Event, 8),
 	}
-	actives[x.streamID] = x
+
+	mu.Lock()
+	lastID++
+	st.id = lastID
+	actives[st.id] = st
 	mu.Unlock()
 
+	lat := x.Latency
+	if lat <= 0 {
+		lat = time.Second / 4
+	}
+
 	context := C.FSEventStreamContext{}
-	info := C.uintptr_t(x.streamID)
-	cfinv := C.CFTimeInterval(float64(x.Latency) / float64(time.Second))
+	info := C.uintptr_t(st.id)
+	cfinv := C.CFTimeInterval(float64(lat) / float64(time.Second))
 
-	x.stream = C.EventStreamCreate(&context, info, cPaths, cfinv)
-	x.obsChan = make(chan []internal.Event, 8)
+	st.ref = C.EventStreamCreate(&context, info, cPaths, cfinv)
 
 	wait := make(chan struct{})
 
 	go func() {
 		runtime.LockOSThread()
-		x.rlref = C.CFRunLoopGetCurrent()
-		C.CFRetain(C.CFTypeRef(x.rlref))
+		st.rlref = C.CFRunLoopGetCurrent()
+		C.CFRetain(C.CFTypeRef(st.rlref))
 
-		C.FSEventStreamScheduleWithRunLoop(x.stream, x.rlref, C.kCFRunLoopDefaultMode)
-		C.FSEventStreamStart(x.stream)
+		C.FSEventStreamScheduleWithRunLoop(st.ref, st.rlref, C.kCFRunLoopDefaultMode)
+		C.FSEventStreamStart(st.ref)
 		close(wait)
 		C.CFRunLoopRun()
 	}()
 
 	<-wait
+	return st
 }
 
 // request fsevents to stop streaming events
-func (x *Interface) stop() {
-	C.FSEventStreamFlushSync(x.stream)
-	C.FSEventStreamStop(x.stream)
-	C.FSEventStreamInvalidate(x.stream)
-	C.FSEventStreamRelease(x.stream)
+func (x *stream) stop() {
+	C.FSEventStreamFlushSync(x.ref)
+	C.FSEventStreamStop(x.ref)
+	C.FSEventStreamInvalidate(x.ref)
+	C.FSEventStreamRelease(x.ref)
 
 	C.CFRunLoopStop(x.rlref)
 	C.CFRelease(C.CFTypeRef(x.rlref))
 
 	close(x.obsChan)
 	mu.Lock()
-	delete(actives, x.streamID)
+	delete(actives, x.id)
 	mu.Unlock()
 }
@@ -4,7 +4,6 @@
 package fsevents
 
 import (
-	"os"
 	"time"
 
 	"github.com/fswatch/fswatch/internal"
@@ -26,22 +25,14 @@ func New(opts map[string]interface{}) *Interface {
 	}
 }
 
-func noop() {}
-
 // Files watches a list of files, calling the observer with any events.
-func (x *Interface) Files(paths []string, obs internal.ObserveFunc) (cancel func(), err error) {
-	x.mu.Lock()
-	if x.Latency <= 0 {
-		x.Latency = time.Second / 4
-	}
-
+func (x *Interface) Files(paths []string, obs internal.ObserveFunc, opts *internal.WatchOptions) (*internal.Watch, error) {
 	/// force stripping of any directories
 	p2 := make([]string, 0, len(paths))
 	for _, fn := range paths {
-		info, err := os.Stat(fn)
+		info, err := opts.Stat(fn)
 		if err != nil {
-			x.mu.Unlock()
-			return noop, err
+			return nil, err
 		}
 		if info.IsDir() {
 			continue
@@ -49,37 +40,38 @@ func (x *Interface) Files(paths []string, obs internal.ObserveFunc) (cancel func
 		p2 = append(p2, fn)
 	}
 
-	x.start(p2)
-
-	go func() {
-		for evts := range x.obsChan {
-			obs(evts)
-		}
-	}()
-
-	return func() {
-		x.stop()
-		x.mu.Unlock()
-	}, nil
+	return x.watch(p2, obs), nil
 }
 
 // Recursively watches all files/folders under the given path, calling the observer with any events.
-func (x *Interface) Recursively(path string, obs internal.ObserveFunc) (cancel func(), err error) {
-	x.mu.Lock()
-	if x.Latency <= 0 {
-		x.Latency = time.Second / 4
-	}
+// FSEvents always watches the whole tree, so directories skipped by the options are
+// filtered by the caller.
+func (x *Interface) Recursively(path string, obs internal.ObserveFunc, opts *internal.WatchOptions) (*internal.Watch, error) {
+	return x.watch([]string{path}, obs), nil
+}
 
-	x.start([]string{path})
+// watch starts a new stream and forwards its events to the observer.
+// If the observer returns an error, the stream is stopped and the
+// watch ends with that error.
+func (x *Interface) watch(paths []string, obs internal.ObserveFunc) *internal.Watch {
+	st := x.start(paths)
+	w := internal.NewWatch(st.stop)
 
 	go func() {
-		for evts := range x.obsChan {
-			obs(evts)
+		failed := false
+		for evts := range st.obsChan {
+			if failed {
+				// keep draining until the stream is stopped
+				continue
+			}
+			if err := obs(evts); err != nil {
+				failed = true
+				// stopping flushes the stream through this goroutine,
+				// so it can't be done from here
+				go w.Close(err)
+			}
 		}
 	}()
 
-	return func() {
-		x.stop()
-		x.mu.Unlock()
-	}, nil
+	return w
 }
@@ -0,0 +1,142 @@
+// Package history records the last change of each path of a watch, so that
+// the paths changed since a point in time can be listed.
+//
+// Points in time are clocks: opaque strings of the form
+// "c:<instance>:<tick>", where the instance identifies the History, and the
+// tick counts the changes it recorded.
+package history
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fswatch/fswatch/internal"
+)
+
+var instances uint64
+
+// MaxEntries is how many paths a History remembers. Once there are more,
+// the oldest half are forgotten, and the changes before them are lost.
+const MaxEntries = 1 << 16
+
+// History records the last change of each path.
+type History struct {
+	instance string
+
+	mu    sync.Mutex
+	tick  uint64           // of the last change
+	lost  uint64           // changes up to this tick may have been lost or forgotten
+	paths map[string]Entry // the last change of each path
+}
+
+// Entry is the last change of a path.
+type Entry struct {
+	Path   string
+	Type   internal.EventType // of the last event for the path
+	Exists bool               // false if it was deleted, or renamed to another path
+	Tick   uint64
+}
+
+// New returns an empty History with a new instance.
+func New() *History {
+	n := atomic.AddUint64(&instances, 1)
+	return &History{
+		instance: fmt.Sprintf("%d-%d-%d", os.Getpid(), time.Now().UnixNano(), n),
+		paths:    make(map[string]Entry),
+	}
+}
+
+// Record records an event, returning its tick. After an OVERFLOW event,
+// or once too many paths are remembered, the changes before it are no
+// longer known.
+func (h *History) Record(e internal.Event) uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.tick++
+	if e.Type == internal.OVERFLOW {
+		h.lost = h.tick
+		return h.tick
+	}
+	if e.OldPath != "" {
+		h.paths[e.OldPath] = Entry{Path: e.OldPath, Type: e.Type, Tick: h.tick}
+	}
+	h.paths[e.Path] = Entry{Path: e.Path, Type: e.Type, Exists: e.Type != internal.DELETED, Tick: h.tick}
+	if len(h.paths) > MaxEntries {
+		h.forget()
+	}
+	return h.tick
+}
+
+// forget forgets the oldest half of the paths, marking their changes lost.
+func (h *History) forget() {
+	ticks := make([]uint64, 0, len(h.paths))
+	for _, e := range h.paths {
+		ticks = append(ticks, e.Tick)
+	}
+	sort.Slice(ticks, func(i, j int) bool {
+		return ticks[i] < ticks[j]
+	})
+	cutoff := ticks[len(ticks)/2]
+	for p, e := range h.paths {
+		if e.Tick <= cutoff {
+			delete(h.paths, p)
+		}
+	}
+	if cutoff > h.lost {
+		h.lost = cutoff
+	}
+}
+
+// Clock returns the clock of the last change.
+func (h *History) Clock() string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.ClockAt(h.tick)
+}
+
+// ClockAt returns the clock of a tick.
+func (h *History) ClockAt(tick uint64) string {
+	return "c:" + h.instance + ":" + strconv.FormatUint(tick, 10)
+}
+
+// parse returns the tick of a clock. It reports false if
+// the clock is not of this History.
+func (h *History) parse(clock string) (uint64, bool) {
+	i := strings.LastIndexByte(clock, ':')
+	if i < 0 || clock[:i] != "c:"+h.instance {
+		return 0, false
+	}
+	tick, err := strconv.ParseUint(clock[i+1:], 10, 64)
+	return tick, err == nil && tick <= h.tick
+}
+
+// Since returns the last change of each path changed since clock, sorted by
+// path, along with the current clock. It reports fresh, with no entries, if
+// the changes are not known: the clock is of another History, or changes were
+// lost since.
+func (h *History) Since(clock string) (entries []Entry, now string, fresh bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	now = h.ClockAt(h.tick)
+	since, ok := h.parse(clock)
+	if !ok || since < h.lost {
+		return nil, now, true
+	}
+	for _, e := range h.paths {
+		if e.Tick > since {
+			entries = append(entries, e)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Path < entries[j].Path
+	})
+	return entries, now, false
+}
@@ -7,6 +7,7 @@ import (
 	"bufio"
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -16,230 +17,682 @@ import (
 	"time"
 
 	"github.com/fswatch/fswatch/internal"
+	"github.com/fswatch/fswatch/internal/snapshot"
 	"golang.org/x/sys/unix"
 )
 
 // New returns a new inotify-based filesystem watcher.
-// It supports 1 option:
-//    "latency" = time.Duration
+// It supports 2 options:
+//    "latency" = time.Duration, how long to collect events before delivering them
+//    "rescan" = bool, rescan watched paths after the event queue overflows
 //
 func New(opts map[string]interface{}) *Interface {
 	lat := time.Second / 4
+	rescan := false
 	if opts != nil {
 		if x, ok := opts["latency"]; ok {
 			lat = x.(time.Duration)
 		}
+		if x, ok := opts["rescan"]; ok {
+			rescan = x.(bool)
+		}
 	}
 	return &Interface{
 		Latency: lat,
+		Rescan:  rescan,
 	}
 }
 
+// Interface is an inotify-based filesystem watcher. It is safe to start any
+// number of watches concurrently; each one gets its own inotify instance.
 type Interface struct {
+	// Latency is how long events are collected before they are delivered
+	// to the observer as a single batch. Zero delivers events immediately.
 	Latency time.Duration
 
-	mu    sync.Mutex
-	fd    int
-	names map[int]string
+	// BufferSize is how many events read from the kernel are queued while
+	// the observer is busy. Zero uses the default of 64.
+	BufferSize int
+
+	// Rescan enables rescanning the watched paths when the kernel event
+	// queue overflows. Every watch then keeps a snapshot of the last known
+	// state of its paths, and the differences found by the rescan are
+	// reported after the OVERFLOW event.
+	Rescan bool
+}
+
+// masks used for watched files and directories
+const (
+	fileMask = uint32(unix.IN_MASK_ADD | unix.IN_MODIFY | unix.IN_DELETE_SELF | unix.IN_MOVE_SELF | unix.IN_ATTRIB)
+	dirMask  = uint32(unix.IN_ONLYDIR | unix.IN_MASK_ADD | unix.IN_MODIFY | unix.IN_CREATE |
+		unix.IN_DELETE | unix.IN_DELETE_SELF | unix.IN_MOVE | unix.IN_MOVE_SELF | unix.IN_ATTRIB)
+)
+
+// defaultBufferSize is how many events are queued by default.
+const defaultBufferSize = 64
+
+// moveWindow is how long a MOVED_FROM waits for its matching MOVED_TO
+// before it is reported as a deletion.
+const moveWindow = 10 * time.Millisecond
+
+// watch is the state of a single inotify instance.
+type watch struct {
+	fd      int
+	file    *os.File
+	handle  *internal.Watch
+	latency time.Duration
+	bufsize int
+
+	fileMask uint32
+	dirMask  uint32
+
+	// mu guards everything below, which is shared
+	// between the event loop and Add or Remove
+	mu     sync.Mutex
+	closed bool // once the descriptor is closed
+	names  map[int]string
 	recur map[int]bool
+	roots map[int]bool
+
+	recursive bool
+	opts      *internal.WatchOptions
+	dirs      []string          // the roots of a recursive watch
+	snap      snapshot.Snapshot // last known state, only kept if rescanning
+
+	// a MOVED_FROM waiting for its MOVED_TO
+	moved    *internal.Event
+	cookie   uint32
+	movedDir bool
+	expire   <-chan time.Time
 }
 
-func noop() {}
+// newWatch creates a new inotify instance. The descriptor is non-blocking so
+// that closing the file also wakes up any pending read.
+func (x *Interface) newWatch(recursive bool, opts *internal.WatchOptions) (*watch, error) {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return nil, fmt.Errorf("inotify: init error, %w", err)
+	}
+	file := os.NewFile(uintptr(fd), "")
+	w := &watch{
+		fd:        fd,
+		file:      file,
+		latency:   x.Latency,
+		bufsize:   x.BufferSize,
+		names:     make(map[int]string),
+		recur:     make(map[int]bool),
+		roots:     make(map[int]bool),
+		recursive: recursive,
+		opts:      opts,
+	}
+	if w.bufsize <= 0 {
+		w.bufsize = defaultBufferSize
+	}
+	w.handle = internal.NewWatch(func() {
+		// the number of the descriptor may be reused once it is closed
+		w.mu.Lock()
+		w.closed = true
+		file.Close()
+		w.mu.Unlock()
+	})
+	w.fileMask, w.dirMask = masks(opts)
+	if x.Rescan {
+		w.snap = make(snapshot.Snapshot)
+	}
+	w.handle.Paths = w
+	return w, nil
+}
 
-func (x *Interface) readEvents(r io.Reader) (internal.Event, error) {
-	evt := internal.Event{
-		Type: internal.OTHER,
+// masks returns the masks for watched files and directories, leaving out
+// modifications and metadata changes if they are not wanted, and not
+// following symlinks if asked to. Creations,
+// deletions and moves are always needed to keep track of the watched tree.
+func masks(opts *internal.WatchOptions) (file, dir uint32) {
+	file, dir = fileMask, dirMask
+	if !opts.Wants(internal.MODIFIED) {
+		file &^= unix.IN_MODIFY
+		dir &^= unix.IN_MODIFY
 	}
+	if !opts.Wants(internal.OTHER) {
+		file &^= unix.IN_ATTRIB
+		dir &^= unix.IN_ATTRIB
+	}
+	if opts != nil && opts.NoFollow {
+		file |= unix.IN_DONT_FOLLOW
+	}
+	return file, dir
+}
 
+// addDir adds a recursive watch for a single directory, reporting
+// whether the directory was not watched before.
+func (x *watch) addDir(pname string) (int, bool, error) {
+	wd, err := unix.InotifyAddWatch(x.fd, pname, x.dirMask)
+	if err != nil {
+		return wd, false, err
+	}
+	_, watched := x.names[wd]
+	x.names[wd] = pname
+	if !strings.HasSuffix(pname, "/") {
+		x.names[wd] += "/"
+	}
+	x.recur[wd] = true
+	return wd, !watched, nil
+}
+
+// addTree adds watches for a directory that appeared inside a recursive watch,
+// along with all of its descendants. Anything found below the directory was
+// created before its watch existed, so it is returned as CREATED events.
+func (x *watch) addTree(path string) []internal.Event {
+	var evts []internal.Event
+	filepath.Walk(path, func(subpath string, info os.FileInfo, e error) error {
+		if e != nil {
+			return nil
+		}
+		if info.IsDir() && x.opts.SkipDir(subpath) {
+			return filepath.SkipDir
+		}
+		if subpath != path {
+			evts = append(evts, internal.Event{Path: subpath, Type: internal.CREATED})
+		}
+		if info.IsDir() {
+			x.addDir(subpath)
+		}
+		return nil
+	})
+	return evts
+}
+
+// moveDir updates the names of all watched directories below oldpath after
+// it was renamed to newpath.
+func (x *watch) moveDir(oldpath, newpath string) {
+	oldpath += "/"
+	for wd, n := range x.names {
+		if strings.HasPrefix(n, oldpath) {
+			x.names[wd] = newpath + "/" + strings.TrimPrefix(n, oldpath)
+		}
+	}
+}
+
+// dropDir removes the watches of a directory (and its descendants) that
+// was moved out of a recursive watch.
+func (x *watch) dropDir(path string) {
+	path += "/"
+	for wd, n := range x.names {
+		if strings.HasPrefix(n, path) {
+			unix.InotifyRmWatch(x.fd, uint32(wd))
+			x.forget(wd)
+		}
+	}
+}
+
+func (x *watch) forget(wd int) {
+	delete(x.names, wd)
+	delete(x.recur, wd)
+	delete(x.roots, wd)
+}
+
+// rawEvent is a single decoded inotify event.
+type rawEvent struct {
+	wd     int
+	mask   uint32
+	cookie uint32
+	name   string
+}
+
+func readEvents(r io.Reader) (rawEvent, error) {
 	ie := unix.InotifyEvent{}
 	err := binary.Read(r, binary.LittleEndian, &ie)
 	if err != nil {
-		return evt, err
+		return rawEvent{}, err
 	}
-
-	if n, ok := x.names[int(ie.Wd)]; ok {
-		evt.Path = n
+	re := rawEvent{
+		wd:     int(ie.Wd),
+		mask:   ie.Mask,
+		cookie: ie.Cookie,
 	}
 
 	if ie.Len > 0 {
 		sname := make([]byte, ie.Len)
 		_, err = io.ReadFull(r, sname)
 		if err != nil {
-			return evt, err
+			return re, err
 		}
 		x := bytes.IndexByte(sname, 0)
 		if x >= 0 {
 			sname = sname[:x]
 		}
-		evt.Path += string(sname)
+		re.name = string(sname)
 	}
+	return re, nil
+}
+
+// run reads events until the watch is closed, delivering them in batches.
+//
+// A rename inside a recursive watch generates a MOVED_FROM and MOVED_TO pair
+// sharing a cookie, which are reported as a single RENAMED event. When only
+// one half arrives (a move into or out of the watched tree) it is reported
+// as CREATED or DELETED instead.
+func (x *watch) run(obs internal.ObserveFunc) {
+	raw := make(chan rawEvent, x.bufsize)
+	go func() {
+		defer close(raw)
+		rd := bufio.NewReader(x.file)
+		for {
+			// read evts from rd
+			re, err := readEvents(rd)
+			if err != nil {
+				if errors.Is(err, os.ErrClosed) {
+					err = nil
+				} else {
+					err = fmt.Errorf("inotify: read error, %w", err)
+				}
+				x.handle.Close(err)
+				return
+			}
+			raw <- re
+		}
+	}()
+
+	b := internal.NewBatcher(x.handle, obs, x.latency)
+	for {
+		var evts []internal.Event
+
+		select {
+		case <-x.expire:
+			x.mu.Lock()
+			evts = x.flush()
+			x.mu.Unlock()
+
+		case <-b.Due():
+			b.Send()
+			continue
+
+		case re, ok := <-raw:
+			if !ok {
+				return
+			}
+			x.mu.Lock()
+			evts = x.process(re)
+			x.mu.Unlock()
+		}
+
+		b.Add(evts)
+	}
+}
 
-	//if (ie.Mask & unix.IN_MOVE) != 0 { // only recursive
-	//   the directory containing a moved file...
-	//}
+// process handles a single raw event, returning the events to report.
+// It must be called with x.mu held.
+func (x *watch) process(re rawEvent) []internal.Event {
+	if (re.mask & unix.IN_Q_OVERFLOW) != 0 {
+		return append(x.flush(), x.track(x.overflow())...)
+	}
 
-	if (ie.Mask & unix.IN_MOVE_SELF) != 0 {
+	evt := x.translate(re)
+	switch {
+	case (re.mask & unix.IN_MOVED_FROM) != 0:
+		evts := x.flush()
+		evt.Type = internal.DELETED
+		x.moved, x.cookie, x.movedDir = &evt, re.cookie, (re.mask&unix.IN_ISDIR) != 0
+		x.expire = time.After(moveWindow)
+		return evts
+
+	case (re.mask & unix.IN_MOVED_TO) != 0:
+		if x.moved != nil && re.cookie == x.cookie {
+			evt.Type = internal.RENAMED
+			evt.OldPath = x.moved.Path
+			x.moved, x.expire = nil, nil
+			res := []internal.Event{evt}
+			if x.movedDir {
+				x.moveDir(evt.OldPath, evt.Path)
+				if x.opts.SkipDir(evt.Path) {
+					x.dropDir(evt.Path)
+				} else if x.opts.SkipDir(evt.OldPath) {
+					res = append(res, x.addTree(evt.Path)...)
+				}
+			}
+			return x.track(res)
+		}
+		evt.Type = internal.CREATED
+	}
+
+	evts := x.flush()
+	if evt.Type == internal.NOTHING {
+		return evts
+	}
+	res := []internal.Event{evt}
+	if evt.Type == internal.CREATED && x.recur[re.wd] && (re.mask&unix.IN_ISDIR) != 0 {
+		res = append(res, x.addTree(evt.Path)...)
+	}
+	return append(evts, x.track(res)...)
+}
+
+// flush reports a pending MOVED_FROM as a deletion.
+// It must be called with x.mu held.
+func (x *watch) flush() []internal.Event {
+	if x.moved == nil {
+		return nil
+	}
+	evt := *x.moved
+	x.moved, x.expire = nil, nil
+	if x.movedDir {
+		x.dropDir(evt.Path)
+	}
+	return x.track([]internal.Event{evt})
+}
+
+// translate converts a raw inotify event into an Event, updating the
+// watch descriptor bookkeeping as needed. Move events are reported as OTHER
+// and must be paired by the caller.
+func (x *watch) translate(re rawEvent) internal.Event {
+	evt := internal.Event{
+		Type: internal.OTHER,
+	}
+
+	n, ok := x.names[re.wd]
+	if !ok {
+		// the watch was already removed
+		evt.Type = internal.NOTHING
+		return evt
+	}
+	evt.Path = n + re.name
+
+	if (re.mask & unix.IN_IGNORED) != 0 {
+		// the watch was removed, either explicitly or because the
+		// file was deleted, so there is nothing left to report
+		x.forget(re.wd)
+		evt.Type = internal.NOTHING
+		return evt
+	}
+
+	if (re.mask & unix.IN_MOVE_SELF) != 0 {
 		// evt.Path is the OLD filename
 		evt.Type = internal.DELETED
 	}
 
-	if (ie.Mask & unix.IN_MODIFY) != 0 {
+	if (re.mask & unix.IN_MODIFY) != 0 {
 		evt.Type = internal.MODIFIED
 	}
 
-	if (ie.Mask & unix.IN_CREATE) != 0 { // only recursive
+	if (re.mask & unix.IN_CREATE) != 0 { // only recursive
 		evt.Type = internal.CREATED
+	}
 
-		if x.recur[int(ie.Wd)] && ((ie.Mask & unix.IN_ISDIR) != 0) {
-			addMask := uint32(unix.IN_ONLYDIR | unix.IN_MASK_ADD | unix.IN_MODIFY | unix.IN_CREATE |
-				unix.IN_DELETE | unix.IN_DELETE_SELF | unix.IN_MOVE | unix.IN_MOVE_SELF | unix.IN_ATTRIB)
+	if (re.mask&unix.IN_DELETE) != 0 || // only recursive
+		(re.mask&unix.IN_DELETE_SELF) != 0 {
+		evt.Type = internal.DELETED
+	}
+
+	if (re.mask&(unix.IN_MOVE_SELF|unix.IN_DELETE_SELF)) != 0 &&
+		x.recur[re.wd] && !x.roots[re.wd] {
+		// the parent directory reports the move or deletion
+		evt.Type = internal.NOTHING
+	}
 
-			wd, werr := unix.InotifyAddWatch(x.fd, evt.Path, addMask)
-			if werr == nil {
-				x.names[wd] = evt.Path
-				x.recur[wd] = true
+	return evt
+}
 
-				if !strings.HasSuffix(evt.Path, "/") {
-					x.names[wd] += "/"
-				}
+// track updates the snapshot with events about to be delivered. Events that
+// the snapshot already reflects are dropped: these were queued before a
+// rescan, but read after it.
+func (x *watch) track(evts []internal.Event) []internal.Event {
+	if x.snap == nil {
+		return evts
+	}
+	res := evts[:0]
+	for _, e := range evts {
+		p := filepath.Clean(e.Path)
+		_, known := x.snap[p]
+		switch e.Type {
+		case internal.DELETED:
+			if !known {
+				continue
+			}
+			x.snap.Remove(p)
+		case internal.RENAMED:
+			old := filepath.Clean(e.OldPath)
+			if _, ok := x.snap[old]; !ok && known {
+				continue
+			}
+			x.snap.Rename(old, p)
+		case internal.CREATED:
+			if known {
+				continue
 			}
+			x.snap.Update(p)
+		case internal.MODIFIED, internal.OTHER:
+			x.snap.Update(p)
 		}
+		res = append(res, e)
 	}
+	return res
+}
 
-	if (ie.Mask&unix.IN_DELETE) != 0 || // only recursive
-		(ie.Mask&unix.IN_DELETE_SELF) != 0 {
-		evt.Type = internal.DELETED
+// overflow returns the events to report after the kernel event queue
+// overflowed. If rescanning, the watched paths are compared with the
+// snapshot, and any directories that appeared in the meantime are watched.
+func (x *watch) overflow() []internal.Event {
+	var evts []internal.Event
+	if !x.recursive {
+		evts = append(evts, internal.Event{Type: internal.OVERFLOW})
+	}
+	for _, dir := range x.dirs {
+		evts = append(evts, internal.Event{Path: dir, Type: internal.OVERFLOW})
+	}
+	if x.snap == nil {
+		return evts
+	}
+
+	var cur snapshot.Snapshot
+	var err error
+	if x.recursive {
+		cur = make(snapshot.Snapshot)
+		for _, dir := range x.dirs {
+			var sub snapshot.Snapshot
+			sub, err = snapshot.Walk(dir, x.opts)
+			if err != nil && !os.IsNotExist(err) {
+				break
+			}
+			for p, info := range sub {
+				cur[p] = info
+				if info.IsDir {
+					x.addDir(p)
+				}
+			}
+		}
+	} else {
+		paths := make([]string, 0, len(x.snap))
+		for p := range x.snap {
+			paths = append(paths, p)
+		}
+		cur, err = snapshot.Files(paths, x.opts)
+	}
+	if err != nil && !os.IsNotExist(err) {
+		// keep the old snapshot, a later overflow may do better
+		return evts
+	}
+	if cur == nil {
+		cur = make(snapshot.Snapshot)
 	}
 
-	return evt, nil
+	// the snapshot catches up as the events are delivered
+	return append(evts, x.snap.Diff(cur)...)
 }
 
-// Files watches a list of files, calling the observer with any events.
-func (x *Interface) Files(paths []string, obs internal.ObserveFunc) (cancel func(), err error) {
+// Add adds paths to a running watch. Directories are ignored unless
+// the watch is recursive, in which case each path is watched recursively.
+// Adding a recursive path again watches any directories no longer skipped.
+// If any path can't be added, none of them are.
+func (x *watch) Add(paths []string) (err error) {
 	x.mu.Lock()
+	defer x.mu.Unlock()
+	if x.closed {
+		return internal.ErrEnded
+	}
 
-	/// force stripping of any directories
-	p2 := make([]string, 0, len(paths))
-	for _, fn := range paths {
-		info, err := os.Stat(fn)
-		if err != nil {
-			x.mu.Unlock()
-			return noop, err
+	// the watches added before a path failed are removed again
+	var added []int
+	ndirs := len(x.dirs)
+	defer func() {
+		if err == nil {
+			return
 		}
-		if info.IsDir() {
-			continue
+		for _, wd := range added {
+			if x.snap != nil {
+				x.snap.Remove(strings.TrimSuffix(x.names[wd], "/"))
+			}
+			unix.InotifyRmWatch(x.fd, uint32(wd))
+			x.forget(wd)
 		}
-		p2 = append(p2, fn)
-	}
+		x.dirs = x.dirs[:ndirs]
+	}()
 
-	fd, err := unix.InotifyInit()
-	if err != nil {
-		x.mu.Unlock()
-		return func() {}, fmt.Errorf("inotify: init error, %w", err)
-	}
-
-	file := os.NewFile(uintptr(fd), "")
-	x.fd = fd
-	if x.recur == nil {
-		x.recur = make(map[int]bool)
+	if !x.recursive {
+		for _, p := range paths {
+			info, err := x.opts.Stat(p)
+			if err != nil {
+				return err
+			}
+			if info.IsDir() {
+				continue
+			}
+			wd, err := unix.InotifyAddWatch(x.fd, p, x.fileMask)
+			if err != nil {
+				return err
+			}
+			if _, ok := x.names[wd]; !ok {
+				added = append(added, wd)
+			}
+			x.names[wd] = p
+			x.recur[wd] = false
+			if x.snap != nil {
+				x.snap[p] = snapshot.NewInfo(info)
+			}
+		}
+		return nil
 	}
-	// files only
-	addMask := uint32(unix.IN_MASK_ADD | unix.IN_MODIFY | unix.IN_DELETE_SELF | unix.IN_MOVE_SELF | unix.IN_ATTRIB)
 
-	x.names = make(map[int]string, len(p2))
-	for _, p := range p2 {
-		wd, err := unix.InotifyAddWatch(fd, p, addMask)
+	for _, path := range paths {
+		// inotify is not recursive, but it can watch folders in bulk
+		// so we collect a list of all descendant folder names
+		var allpaths []string
+		err := filepath.Walk(path, func(subpath string, info os.FileInfo, e error) error {
+			if e != nil {
+				return e
+			}
+			if info.IsDir() {
+				if subpath != path && x.opts.SkipDir(subpath) {
+					return filepath.SkipDir
+				}
+				allpaths = append(allpaths, subpath)
+			}
+			return nil
+		})
 		if err != nil {
-			file.Close()
-			x.mu.Unlock()
-			return func() {}, err
+			return err
 		}
-		x.names[wd] = p
-		x.recur[wd] = false
-	}
 
-	go func(f *os.File) {
-		for {
-			// read evts from rd
-			evt, err := x.readEvents(f)
+		// a path below a root is watched again, without becoming a root
+		covered := x.covers(path)
+		for i, pname := range allpaths {
+			wd, isNew, err := x.addDir(pname)
 			if err != nil {
-				f.Close()
-				return
+				return err
 			}
-			obs([]internal.Event{evt})
+			if isNew {
+				added = append(added, wd)
+			}
+			if i == 0 && !covered {
+				x.roots[wd] = true
+			}
+		}
+		if !covered {
+			x.dirs = append(x.dirs, path)
 		}
-	}(file)
 
-	return func() {
-		file.Close()
-		x.mu.Unlock()
-	}, nil
+		if x.snap != nil {
+			sub, err := snapshot.Walk(path, x.opts)
+			if err != nil {
+				return err
+			}
+			for p, info := range sub {
+				x.snap[p] = info
+			}
+		}
+	}
+	return nil
 }
 
-// Recursively watches all files/folders under the given path, calling the observer with any events.
-func (x *Interface) Recursively(path string, obs internal.ObserveFunc) (cancel func(), err error) {
+// covers reports whether path is already a root of the watch, or below one.
+// It must be called with x.mu held.
+func (x *watch) covers(path string) bool {
+	for _, dir := range x.dirs {
+		if dir == path || strings.HasPrefix(path, strings.TrimSuffix(dir, "/")+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// Remove removes paths from a running watch. For a recursive watch,
+// everything below the paths is removed as well.
+func (x *watch) Remove(paths []string) error {
 	x.mu.Lock()
+	defer x.mu.Unlock()
+	if x.closed {
+		return internal.ErrEnded
+	}
 
-	// inotify is not recursive, but it can watch folders in bulk
-	// so we collect a list of all descendant folder names
-	var allpaths []string
-	err = filepath.Walk(path, func(subpath string, info os.FileInfo, e error) error {
-		if info.IsDir() {
-			allpaths = append(allpaths, subpath)
+	for _, p := range paths {
+		p = filepath.Clean(p)
+		prefix := strings.TrimSuffix(p, "/") + "/"
+		for wd, n := range x.names {
+			if n == p || (x.recursive && strings.HasPrefix(n, prefix)) {
+				unix.InotifyRmWatch(x.fd, uint32(wd))
+				x.forget(wd)
+			}
+		}
+		for i, dir := range x.dirs {
+			if dir == p {
+				x.dirs = append(x.dirs[:i], x.dirs[i+1:]...)
+				break
+			}
+		}
+		if x.snap != nil {
+			x.snap.Remove(p)
 		}
-		return e
-	})
-	if err != nil {
-		x.mu.Unlock()
-		return noop, err
 	}
+	return nil
+}
 
-	fd, err := unix.InotifyInit()
+// Files watches a list of files, calling the observer with any events.
+func (x *Interface) Files(paths []string, obs internal.ObserveFunc, opts *internal.WatchOptions) (*internal.Watch, error) {
+	w, err := x.newWatch(false, opts)
 	if err != nil {
-		x.mu.Unlock()
-		return func() {}, fmt.Errorf("inotify: init error, %w", err)
+		return nil, err
 	}
-
-	file := os.NewFile(uintptr(fd), "")
-	x.fd = fd
-	if x.recur == nil {
-		x.recur = make(map[int]bool)
+	if err = w.Add(paths); err != nil {
+		w.handle.Cancel()
+		return nil, err
 	}
 
-	addMask := uint32(unix.IN_ONLYDIR | unix.IN_MASK_ADD | unix.IN_MODIFY | unix.IN_CREATE |
-		unix.IN_DELETE | unix.IN_DELETE_SELF | unix.IN_MOVE | unix.IN_MOVE_SELF | unix.IN_ATTRIB)
+	go w.run(obs)
 
-	x.names = make(map[int]string, len(allpaths))
-	for _, pname := range allpaths {
-		wd, err := unix.InotifyAddWatch(fd, pname, addMask)
-		if err != nil {
-			file.Close()
-			x.mu.Unlock()
-			return func() {}, err
-		}
-		x.names[wd] = pname
-		if !strings.HasSuffix(pname, "/") {
-			x.names[wd] += "/"
-		}
-		x.recur[wd] = true
-	}
+	return w.handle, nil
+}
 
-	go func(f *os.File) {
-		rd := bufio.NewReader(f)
-		for {
-			// read evts from rd
-			evt, err := x.readEvents(rd)
-			if err != nil {
-				f.Close()
-				return
-			}
+// Recursively watches all files/folders under the given path, calling the observer with any events.
+// Directories skipped by the options are not watched.
+func (x *Interface) Recursively(path string, obs internal.ObserveFunc, opts *internal.WatchOptions) (*internal.Watch, error) {
+	w, err := x.newWatch(true, opts)
+	if err != nil {
+		return nil, err
+	}
+	if err = w.Add([]string{path}); err != nil {
+		w.handle.Cancel()
+		return nil, err
+	}
 
-			obs([]internal.Event{evt})
-		}
-	}(file)
+	go w.run(obs)
 
-	return func() {
-		file.Close()
-		x.mu.Unlock()
-	}, nil
+	return w.handle, nil
 }
@@ -3,117 +3,307 @@ package poller
 import (
 	"errors"
 	"os"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/fswatch/fswatch/internal"
+	"github.com/fswatch/fswatch/internal/snapshot"
 )
 
 // New returns a new polling filesystem watcher, which generates:
+//   CREATED event when a file or folder appears in a recursive watch.
 //   DELETED event when a watched file disappears.
 //   MODIFIED event when the mod time or size changes.
 //   OTHER event when the permissions changes.
 //
 // It supports a "latency" option (of type time.Duration)
-// that specifies how frequently to poll.
+// that specifies how frequently to poll, and a "hash" option (of type bool)
+// to compare the contents of files up to "hash-limit" (of type int64) bytes.
 //
 func New(opts map[string]interface{}) *Interface {
 	lat := time.Second / 4
+	hash := false
+	limit := int64(DefaultHashLimit)
 	if opts != nil {
 		if x, ok := opts["latency"]; ok {
 			lat = x.(time.Duration)
 		}
+		if x, ok := opts["hash"]; ok {
+			hash = x.(bool)
+		}
+		if x, ok := opts["hash-limit"]; ok {
+			limit = x.(int64)
+		}
 	}
 	return &Interface{
-		Latency: lat,
+		Latency:   lat,
+		Hash:      hash,
+		HashLimit: limit,
 	}
 }
 
+// MinLatency is how often paths are polled when events are
+// wanted as soon as possible.
+const MinLatency = 10 * time.Millisecond
+
+// DefaultHashLimit is the default size limit for hashing file contents.
+const DefaultHashLimit = 16 << 20
+
+// racyWindow is how long after a poll a file may still change without
+// its mod time changing, due to the granularity of filesystem timestamps.
+const racyWindow = 2 * time.Second
+
+// Interface is a polling filesystem watcher. It is safe to start any
+// number of watches concurrently; each one polls on its own ticker.
 type Interface struct {
+	// Latency is how often the paths are polled, or MinLatency if it is
+	// zero or less.
 	Latency time.Duration
 
-	mu    sync.Mutex
-	files map[string]*finfo
+	// Hash enables comparing the contents of files, so that MODIFIED is
+	// only reported when the bytes actually changed. A change to just the
+	// mod time is reported as OTHER. Files larger than HashLimit are
+	// compared by size and mod time.
+	Hash      bool
+	HashLimit int64
 }
 
-type finfo struct {
-	isDir bool
-	size  int64
-	mtime int64
-	perms uint32
-}
+// watch is the state of a single polling watch.
+type watch struct {
+	mu        sync.Mutex
+	closed    bool // once the watch ended
+	recursive bool
+	opts      *internal.WatchOptions
+	dirs      []string // the roots of a recursive watch
+	snap      snapshot.Snapshot
+	polled    time.Time // when snap was taken
 
-func noop() {}
+	hash  bool
+	limit int64
+}
 
-// Files watches a list of files, calling the observer with any events.
-func (x *Interface) Files(paths []string, obs internal.ObserveFunc) (cancel func(), err error) {
+// Add adds paths to a running watch. For a recursive
+// watch, each path is watched recursively. Adding a recursive path
+// again polls any directories no longer skipped. If any path can't be
+// added, none of them are.
+func (x *watch) Add(paths []string) (err error) {
 	x.mu.Lock()
-	if x.Latency <= 0 {
-		x.Latency = time.Second / 4
+	defer x.mu.Unlock()
+	if x.closed {
+		return internal.ErrEnded
 	}
 
-	x.files = make(map[string]*finfo, len(paths))
+	// the paths added before a path failed are removed again
+	var added []string
+	ndirs := len(x.dirs)
+	defer func() {
+		if err == nil {
+			return
+		}
+		for _, p := range added {
+			delete(x.snap, p)
+		}
+		x.dirs = x.dirs[:ndirs]
+	}()
+
 	for _, p := range paths {
-		info, err := os.Stat(p)
+		if !x.recursive {
+			info, err := x.opts.Stat(p)
+			if err != nil {
+				return err
+			}
+			if _, ok := x.snap[p]; !ok {
+				added = append(added, p)
+			}
+			x.snap[p] = x.withHash(p, snapshot.NewInfo(info))
+			continue
+		}
+
+		sub, err := snapshot.Walk(p, x.opts)
 		if err != nil {
-			return noop, err
+			return err
+		}
+		for sp, info := range sub {
+			if _, ok := x.snap[sp]; !ok {
+				added = append(added, sp)
+			}
+			x.snap[sp] = x.withHash(sp, info)
+		}
+		if !x.covers(p) {
+			x.dirs = append(x.dirs, p)
+		}
+	}
+	return nil
+}
+
+// covers reports whether p is already a root of the watch, or below one,
+// so that it is walked already. It must be called with x.mu held.
+func (x *watch) covers(p string) bool {
+	for _, dir := range x.dirs {
+		if dir == p || strings.HasPrefix(p, strings.TrimSuffix(dir, "/")+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// Remove removes paths from a running watch. For a recursive
+// watch, everything below the paths is removed as well.
+func (x *watch) Remove(paths []string) error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if x.closed {
+		return internal.ErrEnded
+	}
+
+	for _, p := range paths {
+		if !x.recursive {
+			delete(x.snap, p)
+			continue
+		}
+
+		x.snap.Remove(p)
+		for i, dir := range x.dirs {
+			if dir == p {
+				x.dirs = append(x.dirs[:i], x.dirs[i+1:]...)
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// poll checks all the watched paths for changes.
+func (x *watch) poll() []internal.Event {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	now := time.Now()
+	cur := make(snapshot.Snapshot, len(x.snap))
+	if x.recursive {
+		for _, dir := range x.dirs {
+			sub, err := snapshot.Walk(dir, x.opts)
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				// try again on the next tick
+				return nil
+			}
+			for p, info := range sub {
+				cur[p] = info
+			}
+		}
+	} else {
+		for p, last := range x.snap {
+			info, err := x.opts.Stat(p)
+			if err == nil {
+				cur[p] = snapshot.NewInfo(info)
+			} else if !errors.Is(err, os.ErrNotExist) {
+				cur[p] = last
+			}
 		}
-		x.files[p] = &finfo{
-			isDir: info.IsDir(),
-			size:  info.Size(),
-			perms: uint32(info.Mode().Perm()),
-			mtime: info.ModTime().UnixNano(),
+	}
+
+	if x.hash {
+		for p, info := range cur {
+			cur[p] = x.rehash(p, info)
 		}
 	}
 
-	t := time.NewTicker(x.Latency)
+	res := x.snap.Diff(cur)
+	x.snap = cur
+	x.polled = now
+	return res
+}
+
+// withHash adds the hash of a file's contents to its info, if hashing.
+func (x *watch) withHash(p string, info snapshot.Info) snapshot.Info {
+	if !x.hash || info.IsDir || info.Size > x.limit {
+		return info
+	}
+	if h, err := snapshot.HashFile(p); err == nil {
+		info.Hash = h
+	}
+	return info
+}
+
+// rehash adds the hash of a file's contents to its current info. The
+// contents are only read when the size and mod time can't tell whether they
+// changed: the size is the same, but the mod time changed or is so recent
+// that a write could have happened without changing it.
+func (x *watch) rehash(p string, cur snapshot.Info) snapshot.Info {
+	last, ok := x.snap[p]
+	if !ok || last.IsDir || last.Size != cur.Size {
+		// new or resized, hash it once it settles
+		return cur
+	}
+	racy := time.Unix(0, cur.Mtime).After(x.polled.Add(-racyWindow))
+	if last.Mtime == cur.Mtime && last.Hash != "" && !racy {
+		cur.Hash = last.Hash
+		return cur
+	}
+	return x.withHash(p, cur)
+}
+
+// Files watches a list of files, calling the observer with any events.
+// If the observer returns an error, polling stops and the watch ends with that error.
+func (x *Interface) Files(paths []string, obs internal.ObserveFunc, opts *internal.WatchOptions) (*internal.Watch, error) {
+	return x.watch(paths, false, obs, opts)
+}
+
+// Recursively polls all files/folders under the given path, calling the observer with any events.
+// Every poll walks the whole tree, so this is best suited to small trees or long latencies.
+// Directories skipped by the options are not polled.
+func (x *Interface) Recursively(path string, obs internal.ObserveFunc, opts *internal.WatchOptions) (*internal.Watch, error) {
+	return x.watch([]string{path}, true, obs, opts)
+}
+
+// watch starts polling the paths.
+// If the observer returns an error, polling stops and the watch ends with that error.
+func (x *Interface) watch(paths []string, recursive bool, obs internal.ObserveFunc, opts *internal.WatchOptions) (*internal.Watch, error) {
+	lat := x.Latency
+	if lat <= 0 {
+		lat = MinLatency
+	}
+
+	// hashing only tells MODIFIED and OTHER apart
+	pw := &watch{
+		recursive: recursive,
+		opts:      opts,
+		snap:      make(snapshot.Snapshot, len(paths)),
+		polled:    time.Now(),
+		hash:      x.Hash && (opts.Wants(internal.MODIFIED) || opts.Wants(internal.OTHER)),
+		limit:     x.HashLimit,
+	}
+	if err := pw.Add(paths); err != nil {
+		return nil, err
+	}
+
+	t := time.NewTicker(lat)
+	w := internal.NewWatch(func() {
+		t.Stop()
+		pw.mu.Lock()
+		pw.closed = true
+		pw.mu.Unlock()
+	})
+	w.Paths = pw
 
 	go func() {
-		for range t.C {
-			var res []internal.Event
-
-			for p, last := range x.files {
-				info, err := os.Stat(p)
-				if err == nil {
-					x.files[p] = &finfo{
-						isDir: info.IsDir(),
-						size:  info.Size(),
-						perms: uint32(info.Mode().Perm()),
-						mtime: info.ModTime().UnixNano(),
-					}
-
-					if last.mtime != info.ModTime().UnixNano() ||
-						last.size != info.Size() {
-						res = append(res, internal.Event{Path: p, Type: internal.MODIFIED})
-						continue
-					}
-
-					if last.perms != uint32(info.Mode().Perm()) {
-						res = append(res, internal.Event{Path: p, Type: internal.OTHER})
-						continue
-					}
-				} else {
-					if errors.Is(err, os.ErrNotExist) {
-						res = append(res, internal.Event{Path: p, Type: internal.DELETED})
-						delete(x.files, p)
-						continue
-					}
-				}
+		for {
+			select {
+			case <-w.Done():
+				return
+			case <-t.C:
 			}
 
+			res := pw.poll()
 			if len(res) > 0 {
-				obs(res)
+				if err := obs(res); err != nil {
+					w.Close(err)
+					return
+				}
 			}
 		}
 	}()
 
-	return func() {
-		t.Stop()
-		x.mu.Unlock()
-	}, nil
-}
-
-// Recursively polling is not supported
-func (x *Interface) Recursively(path string, obs internal.ObserveFunc) (cancel func(), err error) {
-	return noop, internal.ErrNotImplemented
+	return w, nil
 }
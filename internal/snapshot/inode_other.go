@@ -0,0 +1,11 @@
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+package snapshot
+
+import "os"
+
+// inode returns 0, as inode numbers are not known on this platform.
+func inode(fi os.FileInfo) uint64 {
+	return 0
+}
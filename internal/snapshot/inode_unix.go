@@ -0,0 +1,17 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package snapshot
+
+import (
+	"os"
+	"syscall"
+)
+
+// inode returns the inode number of a file, or 0 if it is not known.
+func inode(fi os.FileInfo) uint64 {
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		return uint64(st.Ino)
+	}
+	return 0
+}
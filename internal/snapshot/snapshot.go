@@ -0,0 +1,174 @@
+// Package snapshot records the state of a set of paths, so that changes
+// can be detected by comparing two snapshots.
+package snapshot
+
+import (
+	"crypto/sha256"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/fswatch/fswatch/internal"
+)
+
+// Info is the recorded state of a single path.
+type Info struct {
+	IsDir bool
+	Size  int64
+	Mtime int64
+	Mode  uint32 // os.FileMode
+	Inode uint64 // 0 if not known
+	Hash  string // digest of the contents, if known
+}
+
+// NewInfo returns the Info for a file.
+func NewInfo(fi os.FileInfo) Info {
+	return Info{
+		IsDir: fi.IsDir(),
+		Size:  fi.Size(),
+		Mtime: fi.ModTime().UnixNano(),
+		Mode:  uint32(fi.Mode()),
+		Inode: inode(fi),
+	}
+}
+
+// Snapshot maps paths to their recorded state.
+type Snapshot map[string]Info
+
+// Walk returns a snapshot of root and everything below it, except for
+// directories skipped by opts. Anything below root that can't be read is left out.
+func Walk(root string, opts *internal.WatchOptions) (Snapshot, error) {
+	s := make(Snapshot)
+	err := filepath.Walk(root, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			if p != root {
+				// removed while walking, or unreadable
+				return nil
+			}
+			return err
+		}
+		if fi.IsDir() && p != root && opts.SkipDir(p) {
+			return filepath.SkipDir
+		}
+		s[p] = NewInfo(fi)
+		return nil
+	})
+	return s, err
+}
+
+// Files returns a snapshot of the given paths. Paths that
+// do not exist are left out of the snapshot.
+func Files(paths []string, opts *internal.WatchOptions) (Snapshot, error) {
+	s := make(Snapshot, len(paths))
+	for _, p := range paths {
+		fi, err := opts.Stat(p)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		s[p] = NewInfo(fi)
+	}
+	return s, nil
+}
+
+// Update records the current state of path. If path can no longer be read,
+// its previous state is kept, or a zero Info recorded if it is new.
+func (s Snapshot) Update(path string) {
+	fi, err := os.Lstat(path)
+	if err != nil {
+		s[path] = s[path]
+		return
+	}
+	s[path] = NewInfo(fi)
+}
+
+// Remove removes path and anything below it.
+func (s Snapshot) Remove(path string) {
+	delete(s, path)
+	prefix := path + string(filepath.Separator)
+	for p := range s {
+		if strings.HasPrefix(p, prefix) {
+			delete(s, p)
+		}
+	}
+}
+
+// Rename moves the state of oldpath, and anything below it, to newpath.
+func (s Snapshot) Rename(oldpath, newpath string) {
+	if info, ok := s[oldpath]; ok {
+		delete(s, oldpath)
+		s[newpath] = info
+	}
+	prefix := oldpath + string(filepath.Separator)
+	for p, info := range s {
+		if strings.HasPrefix(p, prefix) {
+			delete(s, p)
+			s[filepath.Join(newpath, strings.TrimPrefix(p, prefix))] = info
+		}
+	}
+}
+
+// Diff returns the events that turn s into newer, sorted by path:
+//
+//	CREATED for paths only in newer.
+//	DELETED for paths only in s.
+//	MODIFIED for files whose contents changed.
+//	OTHER for paths whose mode changed, or files whose
+//	  mod time changed while the contents did not.
+//
+// The contents of a file are compared by their hash if both snapshots have
+// one, and by size, mod time and inode otherwise, so a file which was replaced
+// by another one is MODIFIED.
+func (s Snapshot) Diff(newer Snapshot) []internal.Event {
+	var evts []internal.Event
+	for p, old := range s {
+		cur, ok := newer[p]
+		switch {
+		case !ok || cur.IsDir != old.IsDir:
+			evts = append(evts, internal.Event{Path: p, Type: internal.DELETED})
+		case !cur.IsDir && modified(old, cur):
+			evts = append(evts, internal.Event{Path: p, Type: internal.MODIFIED})
+		case cur.Mode != old.Mode || (!cur.IsDir && cur.Mtime != old.Mtime):
+			evts = append(evts, internal.Event{Path: p, Type: internal.OTHER})
+		}
+	}
+	for p, cur := range newer {
+		if old, ok := s[p]; !ok || cur.IsDir != old.IsDir {
+			evts = append(evts, internal.Event{Path: p, Type: internal.CREATED})
+		}
+	}
+	sort.SliceStable(evts, func(i, j int) bool {
+		return evts[i].Path < evts[j].Path
+	})
+	return evts
+}
+
+// modified reports whether the contents of a file changed.
+func modified(old, cur Info) bool {
+	if old.Size != cur.Size {
+		return true
+	}
+	if old.Hash != "" && cur.Hash != "" {
+		return old.Hash != cur.Hash
+	}
+	return old.Mtime != cur.Mtime || (old.Inode != 0 && cur.Inode != 0 && old.Inode != cur.Inode)
+}
+
+// HashFile returns a digest of the contents of a file.
+func HashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err = io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return string(h.Sum(nil)), nil
+}
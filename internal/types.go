@@ -2,7 +2,10 @@
 
 package internal
 
-import "errors"
+import (
+	"errors"
+	"os"
+)
 
 // EventType is the type of events generated by a Watcher.
 type EventType int
@@ -13,13 +16,71 @@ const (
 	DELETED                   // something was deleted
 	MODIFIED                  // contents were modified
 	OTHER                     // something else (metadata?) was modified
+	RENAMED                   // something was renamed
+	OVERFLOW                  // events were lost, the watched paths should be rescanned
 )
 
+// EventMask is a set of event types. The zero value has every type.
+type EventMask uint
+
+// MaskOf returns the set of the given event types.
+func MaskOf(types ...EventType) EventMask {
+	var m EventMask
+	for _, t := range types {
+		m |= 1 << uint(t)
+	}
+	return m
+}
+
+// Has reports whether the set has the event type t.
+func (m EventMask) Has(t EventType) bool {
+	return m == 0 || m&(1<<uint(t)) != 0
+}
+
 type Event struct {
-	Path string
-	Type EventType
+	Path    string
+	OldPath string // the previous path of a RENAMED event
+	Type    EventType
+	IsDir   bool // the path is a directory, if the backend reports it
 }
 
 type ObserveFunc func(evts []Event) error
 
+// WatchOptions are the settings of a single watch.
+type WatchOptions struct {
+	// Skip reports whether a directory below the root of a recursive watch
+	// should not be watched, along with everything below it. It may be nil.
+	Skip func(dir string) bool
+
+	// Events are the types of events wanted by the observer.
+	Events EventMask
+
+	// NoFollow watches symlinks themselves, instead of their targets.
+	NoFollow bool
+}
+
+// Stat returns the FileInfo of a watched path, which describes the symlink
+// itself when symlinks are not followed. It is safe to call on nil options.
+func (o *WatchOptions) Stat(name string) (os.FileInfo, error) {
+	if o != nil && o.NoFollow {
+		return os.Lstat(name)
+	}
+	return os.Stat(name)
+}
+
+// Wants reports whether events of type t are wanted, and is safe to call
+// on nil options.
+func (o *WatchOptions) Wants(t EventType) bool {
+	return o == nil || o.Events.Has(t)
+}
+
+// SkipDir reports whether a directory should be skipped, and is safe to call
+// on nil options.
+func (o *WatchOptions) SkipDir(dir string) bool {
+	return o != nil && o.Skip != nil && o.Skip(dir)
+}
+
 var ErrNotImplemented = errors.New("not implemented")
+
+// ErrEnded is returned when adding or removing paths of a watch which ended.
+var ErrEnded = errors.New("watch ended")
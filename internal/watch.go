@@ -0,0 +1,97 @@
+package internal
+
+import "sync"
+
+// Watch tracks the lifetime of a single running watch.
+type Watch struct {
+	// Paths is set by backends that support changing
+	// the watched paths of a running watch.
+	Paths PathSet
+
+	stop func()
+
+	once sync.Once
+	done chan struct{}
+	err  error
+}
+
+// PathSet adds and removes the paths of a running watch.
+type PathSet interface {
+	Add(paths []string) error
+	Remove(paths []string) error
+}
+
+// NewWatch returns a running Watch, which calls stop when it is closed.
+func NewWatch(stop func()) *Watch {
+	return &Watch{
+		stop: stop,
+		done: make(chan struct{}),
+	}
+}
+
+// Close stops the watch and releases its resources, recording err as the
+// reason the watch ended. Only the first call has any effect.
+func (w *Watch) Close(err error) {
+	w.once.Do(func() {
+		w.err = err
+		if w.stop != nil {
+			w.stop()
+		}
+		close(w.done)
+	})
+}
+
+// Cancel stops the watch without an error.
+func (w *Watch) Cancel() {
+	w.Close(nil)
+}
+
+// Done returns a channel that is closed once the watch has ended.
+func (w *Watch) Done() <-chan struct{} {
+	return w.done
+}
+
+// Err returns the reason the watch ended, or nil if it
+// is still running or was cancelled.
+func (w *Watch) Err() error {
+	select {
+	case <-w.done:
+		return w.err
+	default:
+		return nil
+	}
+}
+
+// Add adds paths to the watch, or returns ErrEnded if it ended.
+func (w *Watch) Add(paths []string) error {
+	if w.Paths == nil {
+		return ErrNotImplemented
+	}
+	if w.ended() {
+		return ErrEnded
+	}
+	return w.Paths.Add(paths)
+}
+
+// Remove removes paths from the watch, or returns ErrEnded if it ended.
+func (w *Watch) Remove(paths []string) error {
+	if w.Paths == nil {
+		return ErrNotImplemented
+	}
+	if w.ended() {
+		return ErrEnded
+	}
+	return w.Paths.Remove(paths)
+}
+
+// ended reports whether the watch ended. Backends must still check that
+// they are running while adding or removing paths, as a watch may end
+// concurrently.
+func (w *Watch) ended() bool {
+	select {
+	case <-w.done:
+		return true
+	default:
+		return false
+	}
+}
@@ -1,23 +1,90 @@
 package fswatch
 
 import (
+	"fmt"
+	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 
 	"github.com/fswatch/fswatch/internal"
+	"github.com/fswatch/fswatch/internal/filter"
+	"github.com/fswatch/fswatch/internal/history"
 )
 
+// Event describes a single change to the filesystem.
+type Event struct {
+	Path    string    // the path that changed
+	OldPath string    // for RENAMED events, the path before the rename
+	Type    EventType // what happened to the path
+
+	// IsDir reports that the path is a directory. It is only set by a
+	// Backend which resolves paths itself, such as a fake for tests, and
+	// is used instead of looking the path up on disk to filter the event.
+	IsDir bool
+}
+
+// Observer observes events on the watched paths.
+// If an error is returned, the observer is not called again: the watch
+// ends, and the error is available from its Err method.
+type Observer interface {
+	Observe(ev Event) error
+}
+
+// A Flusher is an Observer which is told when the events the system delivered
+// together were observed, to handle them as a batch. Flush is only called if
+// any of them were observed, and ends the watch like Observe if it returns an
+// error.
+type Flusher interface {
+	Observer
+	Flush() error
+}
+
 // ObserveFunc observes an event ev on the watched path.
-// If an error is returned, the observer is not called again.
+// If an error is returned, the observer is not called again: the watch
+// ends, and the error is available from its Err method.
+//
+// A RENAMED event is observed with the new path. Use an Observer
+// to also receive the old path.
 type ObserveFunc func(path string, ev EventType) error
 
+// Observe calls f(ev.Path, ev.Type).
+func (f ObserveFunc) Observe(ev Event) error {
+	return f(ev.Path, ev.Type)
+}
+
+// ObserveEventFunc is an adapter to allow the use of ordinary functions
+// as an Observer of the full Event.
+type ObserveEventFunc func(ev Event) error
+
+// Observe calls f(ev).
+func (f ObserveEventFunc) Observe(ev Event) error {
+	return f(ev)
+}
+
+// AsObserver converts a simple observer function into an Observer.
+func AsObserver(f func(path string, ev EventType) error) Observer {
+	return ObserveFunc(f)
+}
+
 //////////////
 
 type oa struct {
-	obs       ObserveFunc
-	remap     map[string]string
-	relprefix string
-	absprefix string
+	obs Observer
+
+	resolver PathResolver // resolves paths instead of the filesystem
+	hist     *history.History
+	events   internal.EventMask
+	noFollow bool
+	filter   *filter.Filter
+	ignore   *filter.Ignore
+	resume   *Snapshot     // of the root of a recursive watch
+	ready    chan struct{} // closed once the changes since resume were observed
+
+	mu    sync.RWMutex
+	w     *internal.Watch   // the running watch, once started
+	remap map[string]string // resolved path => user path, for files
+	roots map[string]string // resolved root => user root, for recursive watches
 }
 
 func (x *oa) O() internal.ObserveFunc {
@@ -25,20 +92,309 @@ func (x *oa) O() internal.ObserveFunc {
 }
 
 func (x *oa) All(evts []internal.Event) error {
+	if x.ready != nil {
+		<-x.ready
+	}
+	return x.all(evts)
+}
+
+// all is like All, without waiting for the changes since a snapshot.
+func (x *oa) all(evts []internal.Event) error {
+	observed := false
 	for _, e := range evts {
-		p := e.Path
-		if x.remap != nil {
-			if p2, ok := x.remap[p]; ok {
-				p = p2
+		if x.ignore != nil {
+			if x.ignore.IsIgnoreFile(e.Path) {
+				x.reload(e.Path)
+			}
+			if e.OldPath != "" && x.ignore.IsIgnoreFile(e.OldPath) {
+				x.reload(e.OldPath)
 			}
 		}
-		if x.relprefix != x.absprefix {
-			p = filepath.Join(x.relprefix, strings.TrimPrefix(p, x.absprefix))
+		if e.Type == internal.RENAMED && !x.events.Has(internal.RENAMED) {
+			ok, err := x.observe(internal.Event{Path: e.OldPath, Type: internal.DELETED})
+			if err != nil {
+				return err
+			}
+			observed = observed || ok
+			e = internal.Event{Path: e.Path, Type: internal.CREATED}
 		}
-		err := x.obs(p, EventType(e.Type))
+		ok, err := x.observe(e)
 		if err != nil {
 			return err
 		}
+		observed = observed || ok
+	}
+	if f, ok := x.obs.(Flusher); ok && observed {
+		return f.Flush()
 	}
 	return nil
 }
+
+// observe passes a single event to the observer, unless it is not wanted,
+// and reports whether it did.
+func (x *oa) observe(e internal.Event) (bool, error) {
+	if e.Type != internal.OVERFLOW && !x.events.Has(e.Type) {
+		return false, nil
+	}
+	if (x.filter != nil || x.ignore != nil) && !x.match(e) {
+		return false, nil
+	}
+	ev := Event{
+		Path:  x.path(e.Path),
+		Type:  EventType(e.Type),
+		IsDir: e.IsDir,
+	}
+	if e.OldPath != "" {
+		ev.OldPath = x.path(e.OldPath)
+	}
+	x.hist.Record(internal.Event{Path: ev.Path, OldPath: ev.OldPath, Type: e.Type})
+	return true, x.obs.Observe(ev)
+}
+
+// match reports whether the filter and ignore files let an event through.
+func (x *oa) match(e internal.Event) bool {
+	if e.Type == internal.OVERFLOW {
+		return true
+	}
+	isDir := e.IsDir
+	if x.resolver == nil && (x.ignore != nil || x.filter.NeedsDir()) {
+		if info, err := os.Lstat(e.Path); err == nil {
+			isDir = info.IsDir()
+		}
+	}
+	return x.keep(e.Path, isDir) || (e.OldPath != "" && x.keep(e.OldPath, isDir))
+}
+
+// keep reports whether a path reported by the backend is of interest.
+func (x *oa) keep(p string, isDir bool) bool {
+	if x.filter != nil && !x.filter.Match(x.rel(p), isDir) {
+		return false
+	}
+	if x.ignore != nil {
+		if root := x.rootOf(p); root != "" && x.ignore.Ignored(root, p, isDir) {
+			return false
+		}
+	}
+	return true
+}
+
+// skip reports whether the backend should not watch a directory, loading
+// its ignore files when it is watched.
+func (x *oa) skip(dir string) bool {
+	if x.filter != nil && x.filter.Skip(x.rel(dir)) {
+		return true
+	}
+	if x.ignore != nil {
+		root := x.rootOf(dir)
+		if root == "" {
+			return false
+		}
+		if x.ignore.Ignored(root, dir, true) {
+			return true
+		}
+		x.ignore.Enter(dir)
+	}
+	return false
+}
+
+// readFile reads an ignore file through a Backend which resolves paths
+// itself. Unless it is a FileReader, there are none.
+func (x *oa) readFile(name string) ([]byte, error) {
+	if fr, ok := x.resolver.(FileReader); ok {
+		return fr.ReadFile(name)
+	}
+	return nil, os.ErrNotExist
+}
+
+// reload reads an ignore file again after it changed, and adds its directory
+// to the watch again so that directories below it which are no longer ignored
+// are watched.
+func (x *oa) reload(p string) {
+	x.mu.RLock()
+	root, w := x.root(p), x.w
+	x.mu.RUnlock()
+	if root == "" {
+		return
+	}
+
+	dir := filepath.Dir(p)
+	x.ignore.Load(dir)
+	if w != nil {
+		// best effort, the backend may not support adding paths
+		w.Add([]string{dir})
+	}
+}
+
+// started records the running watch.
+func (x *oa) started(w *internal.Watch) {
+	x.mu.Lock()
+	x.w = w
+	x.mu.Unlock()
+}
+
+// rel returns a path reported by the backend relative to the root of the watch,
+// with '/' separators. For a watch of files, the path the user asked for is used.
+func (x *oa) rel(p string) string {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	if absprefix := x.root(p); absprefix != "" {
+		p = strings.TrimPrefix(p, absprefix)
+	} else if p2, ok := x.remap[p]; ok {
+		p = p2
+	}
+	return strings.TrimPrefix(filepath.ToSlash(filepath.Clean(p)), "/")
+}
+
+// rootOf returns the innermost root containing p, or "" if there is none.
+func (x *oa) rootOf(p string) string {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	return x.root(p)
+}
+
+// root is like rootOf, but must be called with x.mu held.
+func (x *oa) root(p string) string {
+	absprefix := ""
+	for abs := range x.roots {
+		if len(abs) > len(absprefix) && within(p, abs) {
+			absprefix = abs
+		}
+	}
+	return absprefix
+}
+
+// path maps a path reported by the backend to the path the user asked for.
+func (x *oa) path(p string) string {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	if p2, ok := x.remap[p]; ok {
+		return p2
+	}
+
+	absprefix := x.root(p)
+	if absprefix == "" {
+		return p
+	}
+	if relprefix := x.roots[absprefix]; relprefix != absprefix {
+		p = filepath.Join(relprefix, strings.TrimPrefix(p, absprefix))
+	}
+	return p
+}
+
+// within reports whether p is dir or below it.
+func within(p, dir string) bool {
+	if !strings.HasPrefix(p, dir) {
+		return false
+	}
+	return len(p) == len(dir) || p[len(dir)] == filepath.Separator ||
+		strings.HasSuffix(dir, string(filepath.Separator))
+}
+
+// add resolves user paths for the backend, remembering how to map them back.
+// It also returns the resolved paths which were not mapped before, to forget
+// them if the backend fails to add them.
+func (x *oa) add(paths []string, recursive bool) (p2s, added []string, err error) {
+	p2s = make([]string, len(paths))
+	for i, p := range paths {
+		p2, err := x.resolve(p, recursive)
+		if err != nil {
+			return nil, nil, err
+		}
+		p2s[i] = p2
+	}
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	for i, p := range paths {
+		_, isRoot := x.roots[p2s[i]]
+		_, isRemapped := x.remap[p2s[i]]
+		if !isRoot && !isRemapped {
+			added = append(added, p2s[i])
+		}
+		if recursive {
+			if x.roots == nil {
+				x.roots = make(map[string]string, len(paths))
+			}
+			x.roots[p2s[i]] = p
+			if x.ignore != nil {
+				x.ignore.Load(p2s[i])
+			}
+		} else if p2s[i] != p {
+			if x.remap == nil {
+				x.remap = make(map[string]string, len(paths))
+			}
+			x.remap[p2s[i]] = p
+		}
+	}
+	return p2s, added, nil
+}
+
+// lookup returns the resolved paths that were added for user paths.
+func (x *oa) lookup(paths []string) []string {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	p2s := make([]string, len(paths))
+	for i, p := range paths {
+		p2s[i] = p
+		for abs, rel := range x.remap {
+			if rel == p {
+				p2s[i] = abs
+			}
+		}
+		for abs, rel := range x.roots {
+			if rel == p {
+				p2s[i] = abs
+			}
+		}
+		if p2s[i] != p {
+			continue
+		}
+		if p2, err := x.resolve(p, false); err == nil {
+			p2s[i] = p2
+		} else if abs, err := filepath.Abs(p); err == nil {
+			p2s[i] = abs
+		}
+	}
+	return p2s
+}
+
+// forget stops mapping resolved paths that were removed.
+func (x *oa) forget(p2s []string) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	for _, p := range p2s {
+		delete(x.remap, p)
+		delete(x.roots, p)
+	}
+}
+
+// resolve returns the absolute path of p, with any symlinks evaluated
+// unless they are not followed, or as resolved by the backend.
+func (x *oa) resolve(p string, recursive bool) (string, error) {
+	if x.resolver != nil {
+		return x.resolver.ResolvePath(p)
+	}
+	if !x.noFollow {
+		return resolve(p)
+	}
+	info, err := os.Lstat(p)
+	if err != nil {
+		return "", err
+	}
+	if recursive && info.Mode()&os.ModeSymlink != 0 {
+		return "", fmt.Errorf("fswatch: can't watch symlink %s recursively without following it", p)
+	}
+	return filepath.Abs(p)
+}
+
+// resolve returns the absolute path of p, with any symlinks evaluated.
+func resolve(p string) (string, error) {
+	p2, err := filepath.EvalSymlinks(p)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Abs(p2)
+}
@@ -0,0 +1,195 @@
+package fswatch
+
+import (
+	"fmt"
+	"runtime"
+	"time"
+
+	"github.com/fswatch/fswatch/internal/filter"
+	"github.com/fswatch/fswatch/internal/poller"
+)
+
+// Options configures an Interface. The zero value uses the default
+// backend of the platform with default settings.
+type Options struct {
+	// Backend is the name of the implementation to use: "" for the default
+	// of the platform, "poller" for the generic poller which works everywhere,
+	// "inotify" or "fanotify" on Linux, "fsevents" on macOS, or the name of
+	// a Backend added with Register. The fanotify backend falls back to
+	// inotify where it can't be used; see OptionFanotify.
+	Backend string
+
+	// Latency is how long events are collected before they are delivered as
+	// a batch, or how often the poller polls. Zero uses the default of 250ms,
+	// and a negative value delivers events as soon as they are read, or polls
+	// every 10ms with the poller.
+	Latency time.Duration
+
+	// BufferSize is how many reads from the system are queued while the
+	// observer is busy, by the inotify and fanotify backends. Zero uses the
+	// default of 64.
+	BufferSize int
+
+	// Rescan rescans the watched paths after an OVERFLOW event.
+	// See OptionRescan.
+	Rescan bool
+
+	// Hash makes the poller compare the contents of files up to HashLimit
+	// bytes, 16MiB if zero. See OptionHash.
+	Hash      bool
+	HashLimit int64
+
+	// Include, Exclude and IgnoreFiles filter every watch, as if by the
+	// Include, Exclude and GitIgnore options. IgnoreFiles are the names of
+	// the ignore files to read, such as ".gitignore".
+	Include     []string
+	Exclude     []string
+	IgnoreFiles []string
+
+	// Symlinks is how watched paths which are symlinks are treated.
+	Symlinks SymlinkPolicy
+}
+
+// SymlinkPolicy is how watched paths which are symlinks are treated.
+// Symlinks below the root of a recursive watch are never followed.
+type SymlinkPolicy int
+
+const (
+	// SymlinksFollow watches the target of a symlink, and reports its
+	// events with the path of the symlink.
+	SymlinksFollow SymlinkPolicy = iota
+
+	// SymlinksNoFollow watches a symlink itself, so only changes to the
+	// link are observed. A recursive watch of a symlink is an error.
+	SymlinksNoFollow
+)
+
+// OptionLatency (of type time.Duration) is how long events are collected
+// before they are delivered, or how often the generic poller polls.
+// Zero delivers events as soon as they are read.
+const OptionLatency = "latency"
+
+// ParseOptions converts the map form of options, as taken by New, into
+// Options. An error is returned for unknown keys and values of the wrong type.
+func ParseOptions(opts map[string]interface{}) (Options, error) {
+	return parseOptions(opts, true)
+}
+
+// parseOptions is like ParseOptions, but unless strict, unknown keys, and
+// options which are invalid or not supported by the backend, are ignored.
+func parseOptions(opts map[string]interface{}, strict bool) (Options, error) {
+	var o Options
+	fan := false
+	for k, v := range opts {
+		ok := true
+		switch k {
+		case OptionGenericPoller:
+			o.Backend = "poller"
+		case OptionLatency:
+			o.Latency, ok = v.(time.Duration)
+			if o.Latency == 0 {
+				o.Latency = -1
+			}
+		case OptionRescan:
+			o.Rescan, ok = v.(bool)
+		case OptionHash:
+			o.Hash, ok = v.(bool)
+		case OptionHashLimit:
+			o.HashLimit, ok = v.(int64)
+		case OptionFanotify:
+			fan, ok = v.(bool)
+		default:
+			if !strict {
+				continue
+			}
+			return Options{}, fmt.Errorf("fswatch: unknown option %q", k)
+		}
+		if !ok {
+			return Options{}, fmt.Errorf("fswatch: option %q has the wrong type %T", k, v)
+		}
+	}
+	if fan && o.Backend == "" && runtime.GOOS == "linux" {
+		o.Backend = "fanotify"
+	}
+	if !strict {
+		if o.HashLimit < 0 {
+			o.HashLimit = 0
+		}
+		if o.Backend != "poller" {
+			o.Hash, o.HashLimit = false, 0
+		}
+	}
+	return o, nil
+}
+
+// NewWithOptions returns an Interface configured by o,
+// or an error if any of the options are invalid.
+func NewWithOptions(o Options) (Interface, error) {
+	if err := o.validate(); err != nil {
+		return nil, err
+	}
+
+	w, err := newBackend(o)
+	if err != nil {
+		return nil, err
+	}
+	return &wrap{w: w, opts: o.watchOptions()}, nil
+}
+
+// validate checks the options, except for the backend name.
+func (o Options) validate() error {
+	switch {
+	case o.BufferSize < 0:
+		return fmt.Errorf("fswatch: negative buffer size %d", o.BufferSize)
+	case o.HashLimit < 0:
+		return fmt.Errorf("fswatch: negative hash limit %d", o.HashLimit)
+	case (o.Hash || o.HashLimit != 0) && o.Backend != "poller":
+		return fmt.Errorf("fswatch: hashing needs the poller backend")
+	case o.Symlinks != SymlinksFollow && o.Symlinks != SymlinksNoFollow:
+		return fmt.Errorf("fswatch: unknown symlink policy %d", o.Symlinks)
+	}
+	for _, name := range o.IgnoreFiles {
+		if name == "" {
+			return fmt.Errorf("fswatch: empty ignore file name")
+		}
+	}
+	_, err := filter.New(o.Include, o.Exclude)
+	return err
+}
+
+// latency returns the latency for the backends, which deliver events
+// immediately when it is zero.
+func (o Options) latency() time.Duration {
+	switch {
+	case o.Latency == 0:
+		return time.Second / 4
+	case o.Latency < 0:
+		return 0
+	}
+	return o.Latency
+}
+
+func (o Options) hashLimit() int64 {
+	if o.HashLimit == 0 {
+		return poller.DefaultHashLimit
+	}
+	return o.HashLimit
+}
+
+// watchOptions returns the options for every watch.
+func (o Options) watchOptions() []WatchOption {
+	var opts []WatchOption
+	if len(o.Include) > 0 {
+		opts = append(opts, Include(o.Include...))
+	}
+	if len(o.Exclude) > 0 {
+		opts = append(opts, Exclude(o.Exclude...))
+	}
+	if len(o.IgnoreFiles) > 0 {
+		opts = append(opts, ignoreFiles(o.IgnoreFiles))
+	}
+	if o.Symlinks == SymlinksNoFollow {
+		opts = append(opts, noFollow)
+	}
+	return opts
+}
@@ -8,16 +8,18 @@ import (
 
 // Recursively watches all files/folders under the given path, calling the observer with any events.
 // A recurive watch is the only way to receive CREATED events for new files and folders.
+// Renames within the watched tree are observed as a single RENAMED event; a rename
+// into or out of the tree is observed as CREATED or DELETED respectively.
 //
 // Note: a recursive watch is not always supported by the host operating system, in which case
 // ErrRecursiveUnsupported is returned. In this situation, this code will function similarly:
 //
 //   fileset, _ := fswatch.EnumerateFiles(path, true)
-//   cancel, _ := fswatch.Files(fileset, obs)
+//   w, _ := fswatch.Files(fileset, obs)
 //
 // An important caveat of the code above: you will not receive CREATED notifications for new files.
-func Recursively(path string, obs ObserveFunc) (cancel func(), err error) {
-	return wrapRecursively(impl, path, obs)
+func Recursively(path string, obs Observer, opts ...WatchOption) (w *Watch, err error) {
+	return wrapRecursively(impl, path, obs, opts...)
 }
 
 // ErrRecursiveUnsupported is returned when the host OS does not support a recursive filesystem watch.
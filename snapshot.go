@@ -0,0 +1,315 @@
+package fswatch
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/fswatch/fswatch/internal"
+	"github.com/fswatch/fswatch/internal/snapshot"
+)
+
+// FileState is the recorded state of a path in a Snapshot.
+type FileState struct {
+	Size    int64
+	ModTime time.Time
+	Inode   uint64 // 0 if not known
+	Mode    os.FileMode
+}
+
+// Snapshot is the state of every path below a root at some point in time,
+// which can be saved and compared to the tree later, to find out what changed
+// while it was not watched.
+//
+// A typical program takes a snapshot when it stops watching, saves it with
+// WriteTo, and passes it to ResumeFrom when it starts watching again:
+//
+//	s, _ := fswatch.TakeSnapshot(root)
+//	s.WriteTo(f)
+//	...
+//	s, _ := fswatch.ReadSnapshot(f)
+//	w, _ := fswatch.Recursively(root, obs, fswatch.ResumeFrom(s))
+type Snapshot struct {
+	root  string
+	files snapshot.Snapshot // keyed by path relative to the root, with '/' separators
+}
+
+// TakeSnapshot walks the tree below root, recording the size, mod time, inode
+// and mode of every path. Paths which can't be read are left out. The root is
+// resolved as for a watch, with its symlinks evaluated.
+func TakeSnapshot(root string) (*Snapshot, error) {
+	root, err := resolve(root)
+	if err != nil {
+		return nil, err
+	}
+	files, err := snapshot.Walk(root, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &Snapshot{root: root, files: relative(root, files)}, nil
+}
+
+// relative returns a snapshot of the paths below root keyed by their
+// paths relative to it.
+func relative(root string, s snapshot.Snapshot) snapshot.Snapshot {
+	files := make(snapshot.Snapshot, len(s))
+	for p, info := range s {
+		if rel, err := filepath.Rel(root, p); err == nil {
+			files[filepath.ToSlash(rel)] = info
+		}
+	}
+	return files
+}
+
+// Root returns the absolute path of the root of the snapshot.
+func (s *Snapshot) Root() string {
+	return s.root
+}
+
+// Len returns the number of paths in the snapshot, including the root.
+func (s *Snapshot) Len() int {
+	return len(s.files)
+}
+
+// Lookup returns the state of a path, relative to the root with '/'
+// separators, or "." for the root itself.
+func (s *Snapshot) Lookup(rel string) (FileState, bool) {
+	info, ok := s.files[rel]
+	if !ok {
+		return FileState{}, false
+	}
+	return FileState{
+		Size:    info.Size,
+		ModTime: time.Unix(0, info.Mtime),
+		Inode:   info.Inode,
+		Mode:    os.FileMode(info.Mode),
+	}, true
+}
+
+// Diff returns the events that turn s into newer, sorted by path, with the
+// paths below the root of newer. Paths are matched by their paths relative to
+// the roots, and are:
+//   - CREATED if they are only in newer.
+//   - DELETED if they are only in s.
+//   - MODIFIED for files whose size, mod time or inode changed.
+//   - OTHER for paths whose mode changed.
+//
+// A path which turned from a file into a directory, or back, is DELETED and
+// CREATED.
+func (s *Snapshot) Diff(newer *Snapshot) []Event {
+	var evts []Event
+	for _, e := range s.files.Diff(newer.files) {
+		evts = append(evts, Event{Path: newer.path(e.Path), Type: EventType(e.Type)})
+	}
+	return evts
+}
+
+// Changes takes a new snapshot of the root of s, and returns the events
+// that turn s into it, as Diff does, along with the new snapshot.
+func (s *Snapshot) Changes() ([]Event, *Snapshot, error) {
+	cur, err := TakeSnapshot(s.root)
+	if err != nil {
+		return nil, nil, err
+	}
+	return s.Diff(cur), cur, nil
+}
+
+// path returns the path of rel below the root.
+func (s *Snapshot) path(rel string) string {
+	return filepath.Join(s.root, filepath.FromSlash(rel))
+}
+
+// snapshotMagic starts every saved snapshot, and names the version of the format.
+const snapshotMagic = "fswsnap1"
+
+// maxPathLen bounds the length of paths read from a snapshot.
+const maxPathLen = 1 << 16
+
+// ErrInvalidSnapshot is returned by ReadSnapshot when the data is not a snapshot
+// written by WriteTo, or it is truncated.
+var ErrInvalidSnapshot = errors.New("fswatch: invalid snapshot")
+
+// WriteTo writes the snapshot to w in a compact binary format, which can be
+// read back with ReadSnapshot. It implements io.WriterTo.
+//
+// Paths are written in order, each sharing a prefix with the one before, and
+// their state as variable-length integers, so that a large tree takes a few
+// bytes per path.
+func (s *Snapshot) WriteTo(w io.Writer) (int64, error) {
+	paths := make([]string, 0, len(s.files))
+	for p := range s.files {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	cw := &countWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	buf := make([]byte, binary.MaxVarintLen64)
+	uvarint := func(v uint64) {
+		bw.Write(buf[:binary.PutUvarint(buf, v)])
+	}
+	varint := func(v int64) {
+		bw.Write(buf[:binary.PutVarint(buf, v)])
+	}
+
+	bw.WriteString(snapshotMagic)
+	uvarint(uint64(len(s.root)))
+	bw.WriteString(s.root)
+	uvarint(uint64(len(paths)))
+	prev := ""
+	for _, p := range paths {
+		shared := 0
+		for shared < len(p) && shared < len(prev) && p[shared] == prev[shared] {
+			shared++
+		}
+		uvarint(uint64(shared))
+		uvarint(uint64(len(p) - shared))
+		bw.WriteString(p[shared:])
+		prev = p
+
+		info := s.files[p]
+		varint(info.Size)
+		varint(info.Mtime)
+		uvarint(info.Inode)
+		uvarint(uint64(info.Mode))
+	}
+	err := bw.Flush()
+	return cw.n, err
+}
+
+// countWriter counts the bytes written to w.
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (x *countWriter) Write(p []byte) (int, error) {
+	n, err := x.w.Write(p)
+	x.n += int64(n)
+	return n, err
+}
+
+// ReadSnapshot reads a snapshot written by WriteTo.
+func ReadSnapshot(r io.Reader) (*Snapshot, error) {
+	br := bufio.NewReader(r)
+	var err error
+	uvarint := func() uint64 {
+		if err != nil {
+			return 0
+		}
+		var v uint64
+		v, err = binary.ReadUvarint(br)
+		return v
+	}
+	varint := func() int64 {
+		if err != nil {
+			return 0
+		}
+		var v int64
+		v, err = binary.ReadVarint(br)
+		return v
+	}
+	str := func(n uint64) string {
+		if err != nil {
+			return ""
+		}
+		if n > maxPathLen {
+			err = ErrInvalidSnapshot
+			return ""
+		}
+		b := make([]byte, n)
+		_, err = io.ReadFull(br, b)
+		return string(b)
+	}
+
+	if str(uint64(len(snapshotMagic))) != snapshotMagic && err == nil {
+		err = ErrInvalidSnapshot
+	}
+	s := &Snapshot{root: str(uvarint())}
+	count := uvarint()
+	if err != nil {
+		return nil, invalid(err)
+	}
+	if count < 1<<16 {
+		s.files = make(snapshot.Snapshot, count)
+	} else {
+		s.files = make(snapshot.Snapshot)
+	}
+	prev := ""
+	for i := uint64(0); i < count; i++ {
+		shared := uvarint()
+		if err == nil && shared > uint64(len(prev)) {
+			err = ErrInvalidSnapshot
+		}
+		if err != nil {
+			return nil, invalid(err)
+		}
+		p := prev[:shared] + str(uvarint())
+		info := snapshot.Info{
+			Size:  varint(),
+			Mtime: varint(),
+			Inode: uvarint(),
+			Mode:  uint32(uvarint()),
+		}
+		if err != nil {
+			return nil, invalid(err)
+		}
+		info.IsDir = os.FileMode(info.Mode).IsDir()
+		s.files[p] = info
+		prev = p
+	}
+	return s, nil
+}
+
+// invalid returns the error for data which ended too early as ErrInvalidSnapshot.
+func invalid(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return ErrInvalidSnapshot
+	}
+	return err
+}
+
+// ResumeFrom observes the changes to the tree of a recursive watch since a
+// snapshot of it was taken, as CREATED, DELETED, MODIFIED and OTHER events,
+// before any events of the running watch. The tree is walked again once the
+// watch is running, so a change made meanwhile may be observed twice, but none
+// is missed. Paths are matched by their paths relative to the roots, so the
+// snapshot may be of the same tree at another path.
+//
+// The events are filtered like any other events of the watch. A failure to
+// walk the tree ends the watch with the error. The option has no effect on
+// watches of files.
+func ResumeFrom(s *Snapshot) WatchOption {
+	return func(o *watchOptions) {
+		o.resume = s
+	}
+}
+
+// resumeFrom observes the changes below root since a snapshot, and then lets
+// the events of the running watch through. The watch is closed on errors.
+func (x *oa) resumeFrom(w *internal.Watch, root string, s *Snapshot, opts *internal.WatchOptions) {
+	defer close(x.ready)
+
+	cur, err := snapshot.Walk(root, opts)
+	if err != nil {
+		w.Close(err)
+		return
+	}
+	for _, e := range s.files.Diff(relative(root, cur)) {
+		select {
+		case <-w.Done():
+			return
+		default:
+		}
+		e.Path = filepath.Join(root, filepath.FromSlash(e.Path))
+		if err := x.all([]internal.Event{e}); err != nil {
+			w.Close(err)
+			return
+		}
+	}
+}
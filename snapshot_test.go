@@ -0,0 +1,188 @@
+package fswatch
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/fswatch/fswatch/internal/snapshot"
+)
+
+// writeTree creates files below root, with directories for names ending in '/'.
+func writeTree(t *testing.T, root string, names ...string) {
+	t.Helper()
+	for _, name := range names {
+		p := filepath.Join(root, filepath.FromSlash(name))
+		var err error
+		if name[len(name)-1] == '/' {
+			err = os.MkdirAll(p, 0755)
+		} else if err = os.MkdirAll(filepath.Dir(p), 0755); err == nil {
+			err = os.WriteFile(p, []byte(name), 0644)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, "a", "b/", "b/c", "b/cd", "b/d/e", "long/"+string(bytes.Repeat([]byte("x"), 200)))
+
+	tests := map[string]*Snapshot{
+		"empty": {root: "/nowhere", files: snapshot.Snapshot{}},
+		"info": {root: "/r", files: snapshot.Snapshot{
+			".":   {IsDir: true, Mode: uint32(os.ModeDir | 0755)},
+			"neg": {Size: 1 << 40, Mtime: -1, Inode: 1<<64 - 1, Mode: uint32(os.ModeSymlink | 0777)},
+		}},
+	}
+	s, err := TakeSnapshot(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests["tree"] = s
+
+	for name, s := range tests {
+		var buf bytes.Buffer
+		n, err := s.WriteTo(&buf)
+		if err != nil || n != int64(buf.Len()) {
+			t.Errorf("%s: WriteTo = %d, %v, wrote %d bytes", name, n, err, buf.Len())
+			continue
+		}
+		got, err := ReadSnapshot(&buf)
+		if err != nil {
+			t.Errorf("%s: ReadSnapshot: %v", name, err)
+			continue
+		}
+		if got.Root() != s.Root() || !reflect.DeepEqual(got.files, s.files) {
+			t.Errorf("%s: read %v %v, want %v %v", name, got.root, got.files, s.root, s.files)
+		}
+	}
+
+	if s.Len() != 9 {
+		t.Errorf("Len = %d, want 9", s.Len())
+	}
+	st, ok := s.Lookup("b/c")
+	fi, _ := os.Lstat(filepath.Join(root, "b", "c"))
+	if !ok || st.Size != 3 || st.Mode != fi.Mode() || !st.ModTime.Equal(fi.ModTime()) {
+		t.Errorf("Lookup(b/c) = %+v, %v", st, ok)
+	}
+	if _, ok := s.Lookup("missing"); ok {
+		t.Errorf("Lookup(missing) found it")
+	}
+}
+
+func TestSnapshotSymlink(t *testing.T) {
+	root, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTree(t, root, "a", "b/c")
+	link := filepath.Join(t.TempDir(), "link")
+	if err := os.Symlink(root, link); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := TakeSnapshot(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Root() != root || s.Len() != 4 {
+		t.Errorf("snapshot of a symlink has root %s and %d paths, want %s and 4", s.Root(), s.Len(), root)
+	}
+}
+
+// uvarints encodes values as a saved snapshot does.
+func uvarints(vals ...uint64) []byte {
+	var b []byte
+	buf := make([]byte, binary.MaxVarintLen64)
+	for _, v := range vals {
+		b = append(b, buf[:binary.PutUvarint(buf, v)]...)
+	}
+	return b
+}
+
+func TestReadSnapshotInvalid(t *testing.T) {
+	s := &Snapshot{root: "/r", files: snapshot.Snapshot{
+		"a":  {Size: 1, Mode: 0644},
+		"ab": {Size: 2, Mode: 0644},
+	}}
+	var buf bytes.Buffer
+	s.WriteTo(&buf)
+	data := buf.Bytes()
+
+	tests := map[string][]byte{
+		"empty":       nil,
+		"bad magic":   append([]byte("fswsnap9"), data[len(snapshotMagic):]...),
+		"long root":   append([]byte(snapshotMagic), uvarints(maxPathLen+1)...),
+		"long path":   append([]byte(snapshotMagic), uvarints(0, 1, 0, maxPathLen+1)...),
+		"bad prefix":  append(append([]byte(snapshotMagic), uvarints(0, 1, 1, 1)...), 'a'),
+		"huge count":  append([]byte(snapshotMagic), uvarints(0, 1<<62)...),
+		"bad varint":  append([]byte(snapshotMagic), bytes.Repeat([]byte{0xff}, 11)...),
+		"missing end": data[:len(data)-1],
+	}
+	for i := 0; i < len(data); i++ {
+		tests[fmt.Sprintf("truncated at %d", i)] = data[:i]
+	}
+	for name, data := range tests {
+		_, err := ReadSnapshot(bytes.NewReader(data))
+		if err == nil {
+			t.Errorf("%s: ReadSnapshot succeeded", name)
+		}
+	}
+}
+
+func TestSnapshotDiff(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, "same", "modified", "chmod", "deleted", "dir/", "dir/x", "turned/")
+	old, err := TakeSnapshot(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// make sure mod times differ on filesystems with a coarse resolution
+	later := time.Now().Add(time.Hour)
+	os.WriteFile(filepath.Join(root, "modified"), []byte("modified!"), 0644)
+	os.Chtimes(filepath.Join(root, "modified"), later, later)
+	os.Chmod(filepath.Join(root, "chmod"), 0600)
+	os.Remove(filepath.Join(root, "deleted"))
+	os.RemoveAll(filepath.Join(root, "dir"))
+	os.Remove(filepath.Join(root, "turned"))
+	writeTree(t, root, "created", "turned")
+
+	evts, cur, err := old.Changes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Event{
+		{Path: "chmod", Type: OTHER},
+		{Path: "created", Type: CREATED},
+		{Path: "deleted", Type: DELETED},
+		{Path: "dir", Type: DELETED},
+		{Path: "dir/x", Type: DELETED},
+		{Path: "modified", Type: MODIFIED},
+		{Path: "turned", Type: DELETED},
+		{Path: "turned", Type: CREATED},
+	}
+	for i := range want {
+		want[i].Path = filepath.Join(root, filepath.FromSlash(want[i].Path))
+	}
+	if !reflect.DeepEqual(evts, want) {
+		t.Errorf("Changes = %v, want %v", evts, want)
+	}
+	if evts := cur.Diff(cur); len(evts) != 0 {
+		t.Errorf("Diff of the same snapshot = %v", evts)
+	}
+
+	// paths are matched relative to the roots
+	moved := &Snapshot{root: "/elsewhere", files: cur.files}
+	evts = old.Diff(moved)
+	if len(evts) != len(want) || evts[0].Path != filepath.Join("/elsewhere", "chmod") {
+		t.Errorf("Diff of a moved tree = %v", evts)
+	}
+}
@@ -0,0 +1,322 @@
+// Package sse streams the events of a watch to HTTP clients as server-sent
+// events, for consumption by a browser's EventSource or any other process.
+//
+// Each event is sent as a message with an increasing ID and a JSON payload
+// with its type and paths, relative to the watched root with '/' separators:
+//
+//	id: 7
+//	data: {"type":"RENAMED","path":"src/b.go","old_path":"src/a.go"}
+//
+// Clients may filter the events they receive with query parameters: include
+// and exclude take glob patterns as in fswatch.Include and may be repeated,
+// and events takes a comma-separated list of event types, such as
+// "?include=*.go&exclude=vendor/&events=CREATED,DELETED".
+//
+// Events for a client which is too slow to read them are dropped, and the
+// client receives an OVERFLOW event where they would have been, with the ID
+// of the first dropped event.
+package sse
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fswatch/fswatch"
+	"github.com/fswatch/fswatch/internal/filter"
+)
+
+// DefaultHeartbeat is how often a Handler sends a comment to idle clients by default.
+const DefaultHeartbeat = 15 * time.Second
+
+// bufferSize is how many events are queued for a client which is slow to read them.
+const bufferSize = 256
+
+// Handler is an http.Handler streaming the events of a recursive watch of a
+// root as server-sent events. The watch is started for the first client, and
+// shared by all clients until the last one disconnects. If it fails, every
+// stream ends, and the next client starts it again.
+type Handler struct {
+	// Heartbeat is how often a comment is sent to clients without events,
+	// to keep the connection alive. DefaultHeartbeat is used if it is zero.
+	Heartbeat time.Duration
+
+	x    fswatch.Interface
+	root string
+
+	mu       sync.Mutex
+	w        *fswatch.Watch
+	starting chan struct{} // closed once the watch being started is set, if any
+	clients  map[*client]bool
+	id       uint64 // of the last event
+}
+
+// NewHandler returns a Handler for the events below root, watched with x.
+func NewHandler(x fswatch.Interface, root string) *Handler {
+	return &Handler{
+		x:       x,
+		root:    root,
+		clients: make(map[*client]bool),
+	}
+}
+
+// message is the payload of an event.
+type message struct {
+	Type    string `json:"type"`
+	Path    string `json:"path,omitempty"`
+	OldPath string `json:"old_path,omitempty"`
+}
+
+// record is an event with its ID, as queued for clients.
+type record struct {
+	id  uint64
+	ev  fswatch.Event
+	rel message
+}
+
+// client is a connected client.
+type client struct {
+	filter *filter.Filter
+	events map[fswatch.EventType]bool // all if empty
+
+	ch   chan record
+	lost uint64          // the ID of the first event dropped since the last was sent, guarded by Handler.mu
+	done <-chan struct{} // closed when the watch ended
+}
+
+// ServeHTTP streams events to a client until it disconnects, or the watch
+// fails. Invalid query parameters are answered with 400 Bad Request.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	c, err := newClient(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := h.subscribe(c); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer h.unsubscribe(c)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	heartbeat := h.Heartbeat
+	if heartbeat <= 0 {
+		heartbeat = DefaultHeartbeat
+	}
+	t := time.NewTicker(heartbeat)
+	defer t.Stop()
+
+	for {
+		var err error
+		select {
+		case rec := <-c.ch:
+			err = h.sendLost(w, c, rec.id)
+			if err == nil {
+				err = send(w, rec.id, rec.rel)
+			}
+			if err == nil {
+				err = h.sendLost(w, c, 0)
+			}
+		case <-t.C:
+			_, err = fmt.Fprint(w, ": heartbeat\n\n")
+		case <-c.done:
+			return
+		case <-r.Context().Done():
+			return
+		}
+		if err != nil {
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+// send writes an event to a client.
+func send(w http.ResponseWriter, id uint64, m message) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\ndata: %s\n\n", id, data)
+	return err
+}
+
+// newClient returns a client with the filters of the query of r.
+func newClient(r *http.Request) (*client, error) {
+	q := r.URL.Query()
+	f, err := filter.New(q["include"], q["exclude"])
+	if err != nil {
+		return nil, err
+	}
+	c := &client{
+		filter: f,
+		events: make(map[fswatch.EventType]bool),
+		ch:     make(chan record, bufferSize),
+	}
+	for _, list := range q["events"] {
+		for _, name := range strings.Split(list, ",") {
+			t, ok := fswatch.ParseEventType(strings.ToUpper(strings.TrimSpace(name)))
+			if !ok {
+				return nil, fmt.Errorf("sse: unknown event type %q", name)
+			}
+			c.events[t] = true
+		}
+	}
+	return c, nil
+}
+
+// wants reports whether the client wants an event, calling isDir
+// only if the filter of the client needs to know.
+func (c *client) wants(rec record, isDir func() bool) bool {
+	if rec.ev.Type == fswatch.OVERFLOW {
+		return true
+	}
+	if len(c.events) > 0 && !c.events[rec.ev.Type] {
+		return false
+	}
+	dir := c.filter.NeedsDir() && isDir()
+	return c.filter.Match(rec.rel.Path, dir) ||
+		(rec.rel.OldPath != "" && c.filter.Match(rec.rel.OldPath, dir))
+}
+
+// subscribe adds a client, starting the watch for the first one. The watch
+// is started without holding the lock, since it walks the whole tree, and
+// other clients wait for it meanwhile.
+func (h *Handler) subscribe(c *client) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for h.w == nil {
+		if starting := h.starting; starting != nil {
+			h.mu.Unlock()
+			<-starting
+			h.mu.Lock()
+			continue
+		}
+
+		starting := make(chan struct{})
+		h.starting = starting
+		h.mu.Unlock()
+		w, err := h.x.Recursively(h.root, fswatch.ObserveEventFunc(h.observe))
+		h.mu.Lock()
+		h.starting = nil
+		close(starting)
+		if err != nil {
+			return err
+		}
+		h.w = w
+		go h.ended(w)
+	}
+	c.done = h.w.Done()
+	h.clients[c] = true
+	return nil
+}
+
+// unsubscribe removes a client, cancelling the watch after the last one.
+// The watch is cancelled without holding the lock, since a backend may
+// deliver pending events to observe while it stops.
+func (h *Handler) unsubscribe(c *client) {
+	h.mu.Lock()
+	delete(h.clients, c)
+	var w *fswatch.Watch
+	if len(h.clients) == 0 {
+		w, h.w = h.w, nil
+	}
+	h.mu.Unlock()
+
+	w.Cancel()
+}
+
+// ended forgets a watch which failed, so the next client starts it again.
+func (h *Handler) ended(w *fswatch.Watch) {
+	<-w.Done()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.w == w {
+		h.w = nil
+	}
+}
+
+// observe queues an event for the clients which want it. An event for a
+// client which is too slow to read them is dropped, and the client is sent
+// an OVERFLOW event in place of the dropped events, see sendLost.
+func (h *Handler) observe(ev fswatch.Event) error {
+	rec := record{
+		ev: ev,
+		rel: message{
+			Type:    ev.Type.String(),
+			Path:    h.rel(ev.Path),
+			OldPath: h.rel(ev.OldPath),
+		},
+	}
+
+	var dir *bool
+	isDir := func() bool {
+		if dir == nil {
+			info, err := os.Lstat(ev.Path)
+			d := err == nil && info.IsDir()
+			dir = &d
+		}
+		return *dir
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.id++
+	rec.id = h.id
+	for c := range h.clients {
+		if !c.wants(rec, isDir) {
+			continue
+		}
+		select {
+		case c.ch <- rec:
+		default:
+			if c.lost == 0 {
+				c.lost = rec.id
+			}
+		}
+	}
+	return nil
+}
+
+// sendLost sends an OVERFLOW event to a client in place of the events
+// dropped for it, where they would have been: before the queued event next,
+// if it came after them, or once no events are queued, with next zero. The
+// ID of the OVERFLOW event is the one of the first dropped event, which is
+// not used otherwise.
+func (h *Handler) sendLost(w http.ResponseWriter, c *client, next uint64) error {
+	h.mu.Lock()
+	lost := c.lost
+	if lost == 0 || (next == 0 && len(c.ch) > 0) || (next != 0 && next < lost) {
+		h.mu.Unlock()
+		return nil
+	}
+	c.lost = 0
+	h.mu.Unlock()
+	return send(w, lost, message{Type: fswatch.OVERFLOW.String()})
+}
+
+// rel returns p relative to the root, with '/' separators.
+func (h *Handler) rel(p string) string {
+	if p == "" {
+		return ""
+	}
+	if rel, err := filepath.Rel(h.root, p); err == nil {
+		p = rel
+	}
+	return filepath.ToSlash(p)
+}
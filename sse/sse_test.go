@@ -0,0 +1,256 @@
+package sse
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fswatch/fswatch"
+	"github.com/fswatch/fswatch/fswatchtest"
+)
+
+// stream is a ResponseWriter recording an event stream, whose writes
+// block while it is paused.
+type stream struct {
+	header  http.Header
+	paused  chan struct{} // closed to resume writing
+	writing chan struct{} // closed once a write is paused
+
+	mu   sync.Mutex
+	data strings.Builder
+	once sync.Once
+}
+
+func newStream(paused bool) *stream {
+	s := &stream{header: make(http.Header), paused: make(chan struct{}), writing: make(chan struct{})}
+	if !paused {
+		close(s.paused)
+	}
+	return s
+}
+
+func (s *stream) Header() http.Header { return s.header }
+func (s *stream) WriteHeader(int)     {}
+func (s *stream) Flush()              {}
+
+func (s *stream) Write(p []byte) (int, error) {
+	select {
+	case <-s.paused:
+	default:
+		s.once.Do(func() { close(s.writing) })
+		<-s.paused
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.data.Write(p)
+}
+
+// event is an event as received by a client.
+type event struct {
+	id  uint64
+	msg message
+}
+
+func (e event) String() string {
+	return fmt.Sprintf("%d %s %s", e.id, e.msg.Type, e.msg.Path)
+}
+
+// events returns the events received so far.
+func (s *stream) events(t *testing.T) []event {
+	s.mu.Lock()
+	data := s.data.String()
+	s.mu.Unlock()
+
+	var evts []event
+	for _, block := range strings.Split(data, "\n\n") {
+		var e event
+		var payload string
+		for _, line := range strings.Split(block, "\n") {
+			switch {
+			case strings.HasPrefix(line, "id: "):
+				fmt.Sscan(line[4:], &e.id)
+			case strings.HasPrefix(line, "data: "):
+				payload = line[6:]
+			}
+		}
+		if payload == "" {
+			continue
+		}
+		if err := json.Unmarshal([]byte(payload), &e.msg); err != nil {
+			t.Fatalf("bad payload %q: %v", payload, err)
+		}
+		evts = append(evts, e)
+	}
+	return evts
+}
+
+// wait waits until n events were received, and returns them.
+func (s *stream) wait(t *testing.T, n int) []event {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		evts := s.events(t)
+		if len(evts) >= n || time.Now().After(deadline) {
+			if len(evts) != n {
+				t.Fatalf("received %d events, want %d: %v", len(evts), n, evts)
+			}
+			return evts
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// connect serves a client with a query until the returned function is called,
+// which waits for it to disconnect.
+func connect(t *testing.T, h *Handler, query string, s *stream) func() {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	r := httptest.NewRequest("GET", "/events"+query, nil).WithContext(ctx)
+	h.mu.Lock()
+	n := len(h.clients)
+	h.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		h.ServeHTTP(s, r)
+	}()
+	for {
+		h.mu.Lock()
+		m := len(h.clients)
+		h.mu.Unlock()
+		if m > n {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+func TestFilter(t *testing.T) {
+	f := fswatchtest.New(fswatch.Options{})
+	h := NewHandler(f, "/root")
+	s := newStream(false)
+	disconnect := connect(t, h, "?include=*.go&exclude=vendor/&events=CREATED,RENAMED", s)
+	defer disconnect()
+
+	f.Emit("/root/a.go", fswatch.CREATED)
+	f.Emit("/root/b.txt", fswatch.CREATED)
+	f.Emit("/root/c.go", fswatch.MODIFIED)
+	f.Emit("/root/vendor/d.go", fswatch.CREATED)
+	f.Rename("/root/e.txt", "/root/src/e.go")
+	f.Emit("/root/f.go", fswatch.CREATED)
+
+	got := fmt.Sprint(s.wait(t, 3))
+	want := "[1 CREATED a.go 5 RENAMED src/e.go 6 CREATED f.go]"
+	if got != want {
+		t.Errorf("received %s, want %s", got, want)
+	}
+}
+
+func TestBadQuery(t *testing.T) {
+	h := NewHandler(fswatchtest.New(fswatch.Options{}), "/root")
+	for _, query := range []string{"?events=BOGUS", "?include=["} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/events"+query, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want %d", query, w.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestOverflow(t *testing.T) {
+	f := fswatchtest.New(fswatch.Options{})
+	h := NewHandler(f, "/root")
+	s := newStream(true)
+	disconnect := connect(t, h, "", s)
+	defer disconnect()
+
+	// the first event is taken from the queue, and stuck while writing it
+	f.Emit("/root/0", fswatch.MODIFIED)
+	<-s.writing
+	n := bufferSize + 10
+	for i := 1; i <= n; i++ {
+		f.Emit(fmt.Sprint("/root/", i), fswatch.MODIFIED)
+	}
+	close(s.paused)
+	s.wait(t, bufferSize+2)
+	f.Emit("/root/last", fswatch.MODIFIED)
+
+	evts := s.wait(t, bufferSize+3)
+	for i, e := range evts[:bufferSize+1] {
+		if e.id != uint64(i+1) || e.msg.Type != "MODIFIED" {
+			t.Fatalf("event %d is %v, want ID %d", i, e, i+1)
+		}
+	}
+	lost := evts[bufferSize+1]
+	if lost.id != bufferSize+2 || lost.msg.Type != "OVERFLOW" {
+		t.Errorf("received %v after the queued events, want an OVERFLOW with the ID of the first dropped event", lost)
+	}
+	if last := evts[bufferSize+2]; last.id != uint64(n+2) || last.msg.Path != "last" {
+		t.Errorf("received %v after the OVERFLOW, want the next event", last)
+	}
+}
+
+func TestTeardown(t *testing.T) {
+	f := fswatchtest.New(fswatch.Options{})
+	h := NewHandler(f, "/root")
+
+	s1, s2 := newStream(false), newStream(false)
+	disconnect1 := connect(t, h, "", s1)
+	disconnect2 := connect(t, h, "", s2)
+	if n := f.Watches(); n != 1 {
+		t.Fatalf("%d watches for two clients, want 1", n)
+	}
+	f.Emit("/root/a", fswatch.CREATED)
+	s1.wait(t, 1)
+	s2.wait(t, 1)
+
+	disconnect1()
+	if n := f.Watches(); n != 1 {
+		t.Errorf("%d watches after a client left, want 1", n)
+	}
+	disconnect2()
+	if n := f.Watches(); n != 0 {
+		t.Errorf("%d watches after the last client left, want 0", n)
+	}
+
+	// the next client starts the watch again
+	s3 := newStream(false)
+	disconnect3 := connect(t, h, "", s3)
+	defer disconnect3()
+	f.Emit("/root/b", fswatch.CREATED)
+	if evts := s3.wait(t, 1); evts[0].msg.Path != "b" {
+		t.Errorf("received %v, want b", evts)
+	}
+}
+
+func TestWatchFailed(t *testing.T) {
+	f := fswatchtest.New(fswatch.Options{})
+	h := NewHandler(f, "/root")
+	s := newStream(false)
+	r := httptest.NewRequest("GET", "/events", nil)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		h.ServeHTTP(s, r)
+	}()
+	for f.Watches() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	f.Fail(fmt.Errorf("failed"))
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("stream did not end when the watch failed")
+	}
+}
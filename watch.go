@@ -0,0 +1,161 @@
+package fswatch
+
+import (
+	"errors"
+
+	"github.com/fswatch/fswatch/internal"
+)
+
+// Watch is a handle to a running watch, as returned by File, Files and Recursively.
+//
+// A watch runs until it is cancelled, its observer returns an error, its
+// context is done, or the underlying implementation fails. All methods are safe to call on a nil
+// Watch, which behaves like a watch that has already ended.
+type Watch struct {
+	w         *internal.Watch
+	oa        *oa
+	recursive bool
+}
+
+// ErrUnsupported is returned when an operation is not supported
+// by the underlying implementation.
+var ErrUnsupported = errors.New("fswatch: operation not supported")
+
+// ErrEnded is returned when adding or removing paths of a watch which has ended.
+var ErrEnded = errors.New("fswatch: watch ended")
+
+var closedChan = make(chan struct{})
+
+func init() {
+	close(closedChan)
+}
+
+// Cancel stops the watch and releases its resources.
+// Events still waiting to be delivered are discarded.
+func (x *Watch) Cancel() {
+	if x == nil {
+		return
+	}
+	x.w.Cancel()
+}
+
+// Done returns a channel that is closed when the watch has ended.
+func (x *Watch) Done() <-chan struct{} {
+	if x == nil {
+		return closedChan
+	}
+	return x.w.Done()
+}
+
+// Err returns the reason the watch ended: the error returned by the observer,
+// an error from the underlying implementation, or the context's error for a
+// watch started with one. It returns nil if the watch is still running or
+// was cancelled.
+func (x *Watch) Err() error {
+	if x == nil {
+		return nil
+	}
+	return x.w.Err()
+}
+
+// Add adds paths to a running watch, without interrupting events for the
+// paths already watched. For a watch started with Files, directories are
+// ignored; for a watch started with Recursively, each path is watched
+// recursively. If any of the paths can't be added, none of them are.
+// ErrEnded is returned once the watch has ended.
+func (x *Watch) Add(paths ...string) error {
+	if x == nil {
+		return ErrUnsupported
+	}
+	p2s, added, err := x.oa.add(paths, x.recursive)
+	if err != nil {
+		return err
+	}
+	err = x.w.Add(p2s)
+	if err != nil {
+		// the paths are not watched after all
+		x.oa.forget(added)
+	}
+	return publicErr(err)
+}
+
+// Remove removes paths from a running watch, without interrupting events
+// for the other paths. For a watch started with Recursively, everything below
+// the paths is removed as well. ErrEnded is returned once the watch has ended.
+func (x *Watch) Remove(paths ...string) error {
+	if x == nil {
+		return ErrUnsupported
+	}
+	p2s := x.oa.lookup(paths)
+	if err := x.w.Remove(p2s); err != nil {
+		return publicErr(err)
+	}
+	x.oa.forget(p2s)
+	return nil
+}
+
+// publicErr returns the error of the package for an internal one.
+func publicErr(err error) error {
+	switch err {
+	case internal.ErrNotImplemented:
+		return ErrUnsupported
+	case internal.ErrEnded:
+		return ErrEnded
+	}
+	return err
+}
+
+// Clock is an opaque token naming a point in the history of a watch,
+// as returned by the Clock method of a Watch.
+type Clock string
+
+// Change is the last change to a path, as returned by ChangedSince.
+type Change struct {
+	Path   string    // as observed
+	Type   EventType // of the last event for the path
+	Exists bool      // false if it was deleted, or renamed to another path
+}
+
+// Changes are the changes of a watch since a clock.
+type Changes struct {
+	// Clock is the clock of the last change, to ask for
+	// the changes after these next time.
+	Clock Clock
+
+	// FreshInstance is set if the changes since the clock are not known,
+	// because it is of another watch, or events were lost since, or the
+	// watch no longer remembers them after too many paths changed: the
+	// watched paths should be rescanned. Changes is empty then.
+	FreshInstance bool
+
+	// Changes are the last change of each path changed since the clock,
+	// sorted by path.
+	Changes []Change
+}
+
+// Clock returns the clock of the last change observed by the watch.
+// Every watch counts the events it observes, after filtering, and records
+// the last change of each path, so that the paths changed since a clock can
+// be asked for with ChangedSince. An event is counted before the observer is
+// called, so the clock returned while observing it includes it.
+func (x *Watch) Clock() Clock {
+	if x == nil {
+		return ""
+	}
+	return Clock(x.oa.hist.Clock())
+}
+
+// ChangedSince returns the paths changed since clock c, as observed by the
+// watch, or a fresh instance if they are not known. It can be called after
+// the watch ended, for the changes up to its end.
+func (x *Watch) ChangedSince(c Clock) Changes {
+	if x == nil {
+		return Changes{FreshInstance: true}
+	}
+	entries, now, fresh := x.oa.hist.Since(string(c))
+	res := Changes{Clock: Clock(now), FreshInstance: fresh}
+	for _, e := range entries {
+		res.Changes = append(res.Changes, Change{Path: e.Path, Type: EventType(e.Type), Exists: e.Exists})
+	}
+	return res
+}
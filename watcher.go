@@ -0,0 +1,125 @@
+package fswatch
+
+import (
+	"errors"
+	"sync"
+)
+
+// Watcher delivers events on channels instead of calling an observer,
+// so that they can be used in a select statement.
+type Watcher struct {
+	events chan Event
+	errors chan error
+
+	mu      sync.RWMutex
+	closed  bool
+	done    chan struct{}
+	once    sync.Once
+	wg      sync.WaitGroup
+	watches []*Watch
+}
+
+// errWatcherClosed ends the watches of a Watcher that was closed.
+var errWatcherClosed = errors.New("fswatch: watcher closed")
+
+// NewWatcher returns a Watcher for the given paths, using the default
+// implementation. See Interface.NewWatcher for details.
+func NewWatcher(paths []string, recursive bool, bufsize int, opts ...WatchOption) (*Watcher, error) {
+	return newWatcher(&wrap{w: impl}, paths, recursive, bufsize, opts...)
+}
+
+func newWatcher(x Interface, paths []string, recursive bool, bufsize int, opts ...WatchOption) (*Watcher, error) {
+	if bufsize < 0 {
+		bufsize = 0
+	}
+	w := &Watcher{
+		events: make(chan Event, bufsize),
+		errors: make(chan error, len(paths)),
+		done:   make(chan struct{}),
+	}
+	obs := ObserveEventFunc(w.observe)
+
+	if !recursive {
+		fw, err := x.Files(paths, obs, opts...)
+		if err != nil {
+			return nil, err
+		}
+		w.add(fw)
+		return w, nil
+	}
+
+	for _, p := range paths {
+		rw, err := x.Recursively(p, obs, opts...)
+		if err != nil {
+			w.Close()
+			return nil, err
+		}
+		w.add(rw)
+	}
+	return w, nil
+}
+
+// add starts forwarding the terminal error of a watch.
+func (x *Watcher) add(w *Watch) {
+	x.watches = append(x.watches, w)
+	x.wg.Add(1)
+	go func() {
+		defer x.wg.Done()
+		<-w.Done()
+		if err := w.Err(); err != nil && err != errWatcherClosed {
+			select {
+			case x.errors <- err:
+			case <-x.done:
+			}
+		}
+	}()
+}
+
+// observe observes an event of the watches by sending it on the Events channel.
+// It blocks while the channel is full, until the Watcher is closed.
+func (x *Watcher) observe(ev Event) error {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	if x.closed {
+		return errWatcherClosed
+	}
+	select {
+	case x.events <- ev:
+		return nil
+	case <-x.done:
+		return errWatcherClosed
+	}
+}
+
+// Events returns the channel of observed events. The channel
+// is closed when the Watcher is closed.
+func (x *Watcher) Events() <-chan Event {
+	return x.events
+}
+
+// Errors returns the channel of errors that ended a watch, including
+// errors from the underlying implementation. The channel is closed when the
+// Watcher is closed.
+func (x *Watcher) Errors() <-chan error {
+	return x.errors
+}
+
+// Close stops all watches and closes the Events and Errors channels.
+// It always returns nil, and is safe to call more than once.
+func (x *Watcher) Close() error {
+	x.once.Do(func() {
+		close(x.done)
+		for _, w := range x.watches {
+			w.Cancel()
+		}
+		x.wg.Wait()
+
+		// wait for any observers still sending
+		x.mu.Lock()
+		x.closed = true
+		close(x.events)
+		close(x.errors)
+		x.mu.Unlock()
+	})
+	return nil
+}
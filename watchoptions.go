@@ -0,0 +1,129 @@
+package fswatch
+
+import (
+	"github.com/fswatch/fswatch/internal"
+	"github.com/fswatch/fswatch/internal/filter"
+)
+
+// WatchOption configures a single watch.
+type WatchOption func(*watchOptions)
+
+type watchOptions struct {
+	include []string
+	exclude []string
+	ignore  []string // names of ignore files
+	events  internal.EventMask
+	resume  *Snapshot
+
+	noFollow bool
+}
+
+// Include only observes paths matching any of the glob patterns.
+//
+// Patterns use '/' as the separator, and are matched against paths relative to
+// the root of a recursive watch, or against the paths as given to Files. Each
+// element of a pattern follows the syntax of path.Match, and an element of "**"
+// matches any number of path elements, so "src/**/*.go" matches all Go files
+// below src, and "src/**" everything below src, but not src itself. A pattern without a '/' only matches the last element of a path,
+// so "*.go" matches Go files anywhere. A pattern ending in '/' only matches
+// directories.
+//
+// Directories are always watched unless they are excluded, since
+// files below them may match.
+func Include(patterns ...string) WatchOption {
+	return func(o *watchOptions) {
+		o.include = append(o.include, patterns...)
+	}
+}
+
+// Exclude ignores paths matching any of the glob patterns, along with everything
+// below them: excluded directories are not watched at all, which saves system
+// resources on large trees. See Include for the pattern syntax.
+//
+// For example, Exclude(".git", "node_modules", "vendor/") ignores those
+// folders anywhere in the tree.
+func Exclude(patterns ...string) WatchOption {
+	return func(o *watchOptions) {
+		o.exclude = append(o.exclude, patterns...)
+	}
+}
+
+// GitIgnore ignores paths in a recursive watch as described by the .gitignore
+// files in the watched tree, along with any files with the extra names, such
+// as ".fswatchignore", which use the same syntax. The full gitignore syntax is
+// supported, including negated, anchored and directory-only patterns, with
+// the rules of deeper files taking precedence. Ignore files above the root of
+// the watch do not apply, and the .git folder itself is not ignored unless
+// excluded.
+//
+// The ignore files are read again when they change, after which the
+// directories that are no longer ignored are watched.
+func GitIgnore(extra ...string) WatchOption {
+	return func(o *watchOptions) {
+		o.ignore = append([]string{".gitignore"}, extra...)
+	}
+}
+
+// OnlyEvents only observes events of the given types. OVERFLOW is always
+// observed. If RENAMED is not one of the types, a rename is observed as
+// DELETED for the old path and CREATED for the new path instead, as far as
+// those types are wanted.
+//
+// Where possible, the system is not asked for unwanted events at all: with
+// inotify, a watch without MODIFIED or OTHER does not receive modifications
+// or metadata changes, which saves work on busy trees. Modifications are still
+// received with GitIgnore, to read ignore files again when they are edited.
+func OnlyEvents(types ...EventType) WatchOption {
+	return func(o *watchOptions) {
+		for _, t := range types {
+			o.events |= internal.MaskOf(internal.EventType(t))
+		}
+	}
+}
+
+// ignoreFiles reads ignore files with the given names, like GitIgnore.
+func ignoreFiles(names []string) WatchOption {
+	return func(o *watchOptions) {
+		o.ignore = names
+	}
+}
+
+// noFollow watches symlinks themselves, see SymlinksNoFollow.
+func noFollow(o *watchOptions) {
+	o.noFollow = true
+}
+
+// apply sets up the observer adapter for the options of a watch,
+// and returns the options for the backend.
+func (x *oa) apply(opts []WatchOption) (*internal.WatchOptions, error) {
+	o := watchOptions{}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	x.events = o.events
+	x.noFollow = o.noFollow
+	x.resume = o.resume
+	wo := &internal.WatchOptions{Events: o.events, NoFollow: o.noFollow}
+	if len(o.include) > 0 || len(o.exclude) > 0 {
+		f, err := filter.New(o.include, o.exclude)
+		if err != nil {
+			return nil, err
+		}
+		x.filter = f
+		wo.Skip = x.skip
+	}
+	if len(o.ignore) > 0 {
+		x.ignore = filter.NewIgnore(o.ignore)
+		if x.resolver != nil {
+			x.ignore.ReadFile = x.readFile
+		}
+		wo.Skip = x.skip
+		if wo.Events != 0 {
+			// edits of ignore files are needed to read them again,
+			// and dropped by the adapter if not wanted
+			wo.Events |= internal.MaskOf(internal.MODIFIED)
+		}
+	}
+	return wo, nil
+}
@@ -1,72 +1,115 @@
 package fswatch
 
 import (
-	"path/filepath"
+	"context"
 
 	"github.com/fswatch/fswatch/internal"
+	"github.com/fswatch/fswatch/internal/history"
 )
 
 type watcher interface {
-	Files(paths []string, obs internal.ObserveFunc) (cancel func(), err error)
-	Recursively(path string, obs internal.ObserveFunc) (cancel func(), err error)
+	Files(paths []string, obs internal.ObserveFunc, opts *internal.WatchOptions) (*internal.Watch, error)
+	Recursively(path string, obs internal.ObserveFunc, opts *internal.WatchOptions) (*internal.Watch, error)
 }
 
-func wrapFiles(w watcher, paths []string, obs ObserveFunc) (cancel func(), err error) {
-	var remap map[string]string
-	p2s := make([]string, len(paths))
-	for i, p := range paths {
-		p2, err := filepath.EvalSymlinks(p)
-		if err != nil {
-			return func() {}, err
-		}
-		p2, err = filepath.Abs(p2)
-		if err != nil {
-			return func() {}, err
-		}
-		p2s[i] = p2
-
-		if p2 != p {
-			if remap == nil {
-				remap = make(map[string]string, len(paths))
-			}
-			remap[p2] = p
-		}
+// newOA returns the observer adapter for a watch of w.
+func newOA(w watcher, obs Observer) *oa {
+	x := &oa{obs: obs, hist: history.New()}
+	if a, ok := w.(*adapter); ok {
+		x.resolver, _ = a.b.(PathResolver)
+	}
+	return x
+}
+
+func wrapFiles(w watcher, paths []string, obs Observer, opts ...WatchOption) (*Watch, error) {
+	x := newOA(w, obs)
+	wo, err := x.apply(opts)
+	if err != nil {
+		return nil, err
+	}
+	p2s, _, err := x.add(paths, false)
+	if err != nil {
+		return nil, err
 	}
 
-	x := &oa{obs: obs, remap: remap}
-	return w.Files(p2s, x.O())
+	iw, err := w.Files(p2s, x.O(), wo)
+	if err != nil {
+		return nil, err
+	}
+	return &Watch{w: iw, oa: x}, nil
 }
 
-func wrapRecursively(w watcher, path string, obs ObserveFunc) (cancel func(), err error) {
-	p2, err := filepath.EvalSymlinks(path)
+func wrapRecursively(w watcher, path string, obs Observer, opts ...WatchOption) (*Watch, error) {
+	x := newOA(w, obs)
+	wo, err := x.apply(opts)
 	if err != nil {
-		return func() {}, err
+		return nil, err
 	}
-	p2, err = filepath.Abs(p2)
+	p2s, _, err := x.add([]string{path}, true)
 	if err != nil {
-		return func() {}, err
+		return nil, err
 	}
 
-	x := &oa{obs: obs, relprefix: path, absprefix: p2}
-	c, e := w.Recursively(p2, x.O())
-	if e == internal.ErrNotImplemented {
-		return func() {}, ErrRecursiveUnsupported
+	if x.resume != nil {
+		x.ready = make(chan struct{})
+	}
+	iw, err := w.Recursively(p2s[0], x.O(), wo)
+	if err == internal.ErrNotImplemented {
+		return nil, ErrRecursiveUnsupported
+	}
+	if err != nil {
+		return nil, err
+	}
+	x.started(iw)
+	if x.resume != nil {
+		go x.resumeFrom(iw, p2s[0], x.resume, wo)
 	}
-	return c, e
+	return &Watch{w: iw, oa: x, recursive: true}, nil
 }
 
 type wrap struct {
-	w watcher
+	w    watcher
+	opts []WatchOption // for every watch
+}
+
+// with returns the options for a watch, after those for every watch.
+func (x *wrap) with(opts []WatchOption) []WatchOption {
+	if len(x.opts) == 0 {
+		return opts
+	}
+	return append(append([]WatchOption(nil), x.opts...), opts...)
+}
+
+func (x *wrap) File(path string, obs Observer, opts ...WatchOption) (*Watch, error) {
+	return wrapFiles(x.w, []string{path}, obs, x.with(opts)...)
+}
+
+func (x *wrap) Files(paths []string, obs Observer, opts ...WatchOption) (*Watch, error) {
+	return wrapFiles(x.w, paths, obs, x.with(opts)...)
+}
+
+func (x *wrap) Recursively(path string, obs Observer, opts ...WatchOption) (*Watch, error) {
+	return wrapRecursively(x.w, path, obs, x.with(opts)...)
+}
+
+func (x *wrap) FileContext(ctx context.Context, path string, obs Observer, opts ...WatchOption) (*Watch, error) {
+	return withContext(ctx, func() (*Watch, error) {
+		return wrapFiles(x.w, []string{path}, obs, x.with(opts)...)
+	})
 }
 
-func (x *wrap) File(path string, obs ObserveFunc) (cancel func(), err error) {
-	return wrapFiles(x.w, []string{path}, obs)
+func (x *wrap) FilesContext(ctx context.Context, paths []string, obs Observer, opts ...WatchOption) (*Watch, error) {
+	return withContext(ctx, func() (*Watch, error) {
+		return wrapFiles(x.w, paths, obs, x.with(opts)...)
+	})
 }
 
-func (x *wrap) Files(paths []string, obs ObserveFunc) (cancel func(), err error) {
-	return wrapFiles(x.w, paths, obs)
+func (x *wrap) RecursivelyContext(ctx context.Context, path string, obs Observer, opts ...WatchOption) (*Watch, error) {
+	return withContext(ctx, func() (*Watch, error) {
+		return wrapRecursively(x.w, path, obs, x.with(opts)...)
+	})
 }
 
-func (x *wrap) Recursively(path string, obs ObserveFunc) (cancel func(), err error) {
-	return wrapRecursively(x.w, path, obs)
+func (x *wrap) NewWatcher(paths []string, recursive bool, bufsize int, opts ...WatchOption) (*Watcher, error) {
+	return newWatcher(x, paths, recursive, bufsize, opts...)
 }